@@ -114,6 +114,8 @@ func (sdn *OpenShiftSDN) Run(c *cobra.Command, errout io.Writer, stopCh chan str
 	}
 
 	<-stopCh
+	// Release any egress IPs hosted here so the master can re-home them
+	sdn.OsdnNode.DrainEgressIPs()
 	time.Sleep(500 * time.Millisecond) // gracefully shut down
 	os.Exit(1)
 }
@@ -79,6 +79,25 @@ func ParseNetworkInfo(clusterNetwork []networkapi.ClusterNetworkEntry, serviceNe
 	}, nil
 }
 
+// CheckServiceNetworkOverlap returns an error if the service network shares
+// any addresses with a cluster network CIDR, so a misconfiguration is caught
+// at startup instead of surfacing as connectivity bugs later.
+func (ni *NetworkInfo) CheckServiceNetworkOverlap() error {
+	for _, cn := range ni.ClusterNetworks {
+		if cidrsOverlap(cn.ClusterCIDR, ni.ServiceNetwork) {
+			return fmt.Errorf("service network %s overlaps with cluster network %s", ni.ServiceNetwork.String(), cn.ClusterCIDR.String())
+		}
+	}
+	return nil
+}
+
+// cidrsOverlap reports whether two parsed networks share any addresses. The
+// networks are in canonical form (base address masked), so they intersect
+// exactly when one contains the other's base address.
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
 func (ni *NetworkInfo) ValidateNodeIP(nodeIP string) error {
 	if nodeIP == "" || nodeIP == "127.0.0.1" {
 		return fmt.Errorf("invalid node IP %q", nodeIP)
@@ -119,6 +119,61 @@ func dummyPod(ip string) kapi.Pod {
 	return kapi.Pod{Status: kapi.PodStatus{PodIP: ip}}
 }
 
+func Test_validateNodeIP(t *testing.T) {
+	networkInfo := &NetworkInfo{
+		ClusterNetworks: []ClusterNetwork{
+			{ClusterCIDR: mustParseCIDR("10.128.0.0/14"), HostSubnetLength: 8},
+		},
+		ServiceNetwork: mustParseCIDR("172.30.0.0/16"),
+	}
+
+	tests := []struct {
+		name        string
+		nodeIP      string
+		expectError bool
+	}{
+		{
+			name:        "valid",
+			nodeIP:      "192.168.1.5",
+			expectError: false,
+		},
+		{
+			name:        "empty",
+			nodeIP:      "",
+			expectError: true,
+		},
+		{
+			name:        "localhost",
+			nodeIP:      "127.0.0.1",
+			expectError: true,
+		},
+		{
+			name:        "unparseable",
+			nodeIP:      "not-an-ip",
+			expectError: true,
+		},
+		{
+			name:        "inside cluster network",
+			nodeIP:      "10.130.0.5",
+			expectError: true,
+		},
+		{
+			name:        "inside service network",
+			nodeIP:      "172.30.99.99",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		err := networkInfo.ValidateNodeIP(test.nodeIP)
+		if err == nil && test.expectError {
+			t.Fatalf("test %q unexpectedly did not get an error", test.name)
+		} else if err != nil && !test.expectError {
+			t.Fatalf("test %q got unexpected error: %v", test.name, err)
+		}
+	}
+}
+
 func Test_checkClusterObjects(t *testing.T) {
 	subnets := []networkapi.HostSubnet{
 		dummySubnet("192.168.1.2", "10.128.0.0/23"),
@@ -261,3 +316,59 @@ func Test_parseNetworkInfo(t *testing.T) {
 		}
 	}
 }
+
+func Test_checkServiceNetworkOverlap(t *testing.T) {
+	tests := []struct {
+		name           string
+		cidrs          []networkapi.ClusterNetworkEntry
+		serviceNetwork string
+		err            string
+	}{
+		{
+			name:           "disjoint",
+			cidrs:          []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/14"}},
+			serviceNetwork: "172.30.0.0/16",
+			err:            "",
+		},
+		{
+			name:           "adjacent",
+			cidrs:          []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/15"}},
+			serviceNetwork: "10.130.0.0/15",
+			err:            "",
+		},
+		{
+			name:           "service network inside cluster network",
+			cidrs:          []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/14"}},
+			serviceNetwork: "10.129.0.0/16",
+			err:            "service network 10.129.0.0/16 overlaps with cluster network 10.128.0.0/14",
+		},
+		{
+			name:           "cluster network inside service network",
+			cidrs:          []networkapi.ClusterNetworkEntry{{CIDR: "172.30.4.0/24"}},
+			serviceNetwork: "172.30.0.0/16",
+			err:            "service network 172.30.0.0/16 overlaps with cluster network 172.30.4.0/24",
+		},
+		{
+			name:           "overlap with second cluster network",
+			cidrs:          []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/14"}, {CIDR: "10.4.0.0/16"}},
+			serviceNetwork: "10.4.0.0/16",
+			err:            "service network 10.4.0.0/16 overlaps with cluster network 10.4.0.0/16",
+		},
+	}
+	for _, test := range tests {
+		networkInfo, err := ParseNetworkInfo(test.cidrs, test.serviceNetwork, nil)
+		if err != nil {
+			t.Fatalf("test %q: unexpected error parsing network info: %v", test.name, err)
+		}
+		err = networkInfo.CheckServiceNetworkOverlap()
+		if err == nil {
+			if len(test.err) > 0 {
+				t.Fatalf("test %q unexpectedly did not get an error", test.name)
+			}
+		} else {
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("test %q: error did not match %q: %v", test.name, test.err, err)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"os"
@@ -61,11 +62,38 @@ type EgressIPWatcher interface {
 	UpdateEgressCIDRs()
 }
 
+// EgressIPConflictHandler is notified when multiple nodes or multiple
+// namespaces claim the same egress IP, so a higher layer can surface the
+// conflict to users (eg as an event or status condition) rather than it
+// being visible only in the logs.
+type EgressIPConflictHandler interface {
+	RecordNodeEgressIPConflict(egressIP string, nodeIPs []string)
+	RecordNamespaceEgressIPConflict(egressIP string, vnids []uint32)
+}
+
+// EgressIPAllocationPreference selects which eligible node gets an egress IP
+// when more than one node's EgressCIDRs cover it.
+type EgressIPAllocationPreference string
+
+const (
+	// PreferLeastLoadedNode picks the eligible node currently hosting the
+	// fewest egress IPs, breaking ties by lowest node IP. This is the default.
+	PreferLeastLoadedNode EgressIPAllocationPreference = "least-loaded"
+	// PreferLowestNodeIP always picks the eligible node with the lowest IP,
+	// regardless of how many egress IPs it already hosts.
+	PreferLowestNodeIP EgressIPAllocationPreference = "lowest-node-ip"
+)
+
 type EgressIPTracker struct {
 	sync.Mutex
 
 	watcher EgressIPWatcher
 
+	allocationPreference EgressIPAllocationPreference
+	// 0 means no limit
+	namespaceEgressIPLimit int
+	conflictHandler        EgressIPConflictHandler
+
 	nodes            map[ktypes.UID]*nodeEgress
 	nodesByNodeIP    map[string]*nodeEgress
 	namespacesByVNID map[uint32]*namespaceEgress
@@ -81,6 +109,8 @@ func NewEgressIPTracker(watcher EgressIPWatcher) *EgressIPTracker {
 	return &EgressIPTracker{
 		watcher: watcher,
 
+		allocationPreference: PreferLeastLoadedNode,
+
 		nodes:            make(map[ktypes.UID]*nodeEgress),
 		nodesByNodeIP:    make(map[string]*nodeEgress),
 		namespacesByVNID: make(map[uint32]*namespaceEgress),
@@ -91,6 +121,25 @@ func NewEgressIPTracker(watcher EgressIPWatcher) *EgressIPTracker {
 	}
 }
 
+// SetAllocationPreference configures how findEgressIPAllocation chooses
+// between multiple eligible nodes. It must be called before Start.
+func (eit *EgressIPTracker) SetAllocationPreference(preference EgressIPAllocationPreference) {
+	eit.allocationPreference = preference
+}
+
+// SetNamespaceEgressIPLimit caps how many egress IPs a single NetNamespace may
+// request; IPs beyond the limit are ignored with a warning. 0 (the default)
+// means no limit. It must be called before Start.
+func (eit *EgressIPTracker) SetNamespaceEgressIPLimit(limit int) {
+	eit.namespaceEgressIPLimit = limit
+}
+
+// SetConflictHandler registers a handler notified of egress IP conflicts.
+// It must be called before Start.
+func (eit *EgressIPTracker) SetConflictHandler(handler EgressIPConflictHandler) {
+	eit.conflictHandler = handler
+}
+
 func (eit *EgressIPTracker) Start(hostSubnetInformer networkinformers.HostSubnetInformer, netNamespaceInformer networkinformers.NetNamespaceInformer) {
 	eit.watchHostSubnets(hostSubnetInformer)
 	eit.watchNetNamespaces(netNamespaceInformer)
@@ -284,20 +333,26 @@ func (eit *EgressIPTracker) UpdateNetNamespaceEgress(netns *networkapi.NetNamesp
 	eit.Lock()
 	defer eit.Unlock()
 
+	egressIPs := netns.EgressIPs
+	if eit.namespaceEgressIPLimit > 0 && len(egressIPs) > eit.namespaceEgressIPLimit {
+		utilruntime.HandleError(fmt.Errorf("NetNamespace %q requests %d egress IPs but the limit is %d; ignoring the rest", netns.NetName, len(egressIPs), eit.namespaceEgressIPLimit))
+		egressIPs = egressIPs[:eit.namespaceEgressIPLimit]
+	}
+
 	ns := eit.namespacesByVNID[netns.NetID]
 	if ns == nil {
-		if len(netns.EgressIPs) == 0 {
+		if len(egressIPs) == 0 {
 			return
 		}
 		ns = &namespaceEgress{vnid: netns.NetID}
 		eit.namespacesByVNID[netns.NetID] = ns
-	} else if len(netns.EgressIPs) == 0 {
+	} else if len(egressIPs) == 0 {
 		delete(eit.namespacesByVNID, netns.NetID)
 	}
 
 	oldRequestedIPs := sets.NewString(ns.requestedIPs...)
-	newRequestedIPs := sets.NewString(netns.EgressIPs...)
-	ns.requestedIPs = netns.EgressIPs
+	newRequestedIPs := sets.NewString(egressIPs...)
+	ns.requestedIPs = egressIPs
 
 	// Process new and removed EgressIPs
 	for _, ip := range newRequestedIPs.Difference(oldRequestedIPs).UnsortedList() {
@@ -329,9 +384,23 @@ func (eit *EgressIPTracker) egressIPActive(eg *egressIPInfo) (bool, error) {
 		return false, nil
 	}
 	if len(eg.nodes) > 1 {
+		if eit.conflictHandler != nil {
+			nodeIPs := make([]string, len(eg.nodes))
+			for i, node := range eg.nodes {
+				nodeIPs[i] = node.nodeIP
+			}
+			eit.conflictHandler.RecordNodeEgressIPConflict(eg.ip, nodeIPs)
+		}
 		return false, fmt.Errorf("Multiple nodes (%s, %s) claiming EgressIP %s", eg.nodes[0].nodeIP, eg.nodes[1].nodeIP, eg.ip)
 	}
 	if len(eg.namespaces) > 1 {
+		if eit.conflictHandler != nil {
+			vnids := make([]uint32, len(eg.namespaces))
+			for i, ns := range eg.namespaces {
+				vnids[i] = ns.vnid
+			}
+			eit.conflictHandler.RecordNamespaceEgressIPConflict(eg.ip, vnids)
+		}
 		return false, fmt.Errorf("Multiple namespaces (%d, %d) claiming EgressIP %s", eg.namespaces[0].vnid, eg.namespaces[1].vnid, eg.ip)
 	}
 	for _, ip := range eg.namespaces[0].requestedIPs {
@@ -498,29 +567,59 @@ func (eit *EgressIPTracker) Ping(ip string, timeout time.Duration) bool {
 // Finds the best node to allocate the egress IP to, given the existing allocation. The
 // boolean return value indicates whether multiple nodes could host the IP.
 func (eit *EgressIPTracker) findEgressIPAllocation(ip net.IP, allocation map[string][]string) (string, bool) {
-	bestNode := ""
+	var bestNode *nodeEgress
 	otherNodes := false
 
 	for _, node := range eit.nodes {
 		if node.offline {
 			continue
 		}
-		egressIPs := allocation[node.nodeName]
+		eligible := false
 		for _, parsed := range node.parsedCIDRs {
 			if parsed.Contains(ip) {
-				if bestNode != "" {
-					otherNodes = true
-					if len(allocation[bestNode]) < len(egressIPs) {
-						break
-					}
-				}
-				bestNode = node.nodeName
+				eligible = true
 				break
 			}
 		}
+		if !eligible {
+			continue
+		}
+		if bestNode != nil {
+			otherNodes = true
+			if !eit.preferNode(node, bestNode, allocation) {
+				continue
+			}
+		}
+		bestNode = node
 	}
 
-	return bestNode, otherNodes
+	if bestNode == nil {
+		return "", false
+	}
+	return bestNode.nodeName, otherNodes
+}
+
+// preferNode returns true if candidate should host an egress IP rather than
+// current, according to the tracker's allocation preference. Ties are broken
+// by lowest node IP so the result does not depend on map iteration order.
+func (eit *EgressIPTracker) preferNode(candidate, current *nodeEgress, allocation map[string][]string) bool {
+	if eit.allocationPreference != PreferLowestNodeIP {
+		candidateLoad := len(allocation[candidate.nodeName])
+		currentLoad := len(allocation[current.nodeName])
+		if candidateLoad != currentLoad {
+			return candidateLoad < currentLoad
+		}
+	}
+	return nodeIPLess(candidate.nodeIP, current.nodeIP)
+}
+
+func nodeIPLess(a, b string) bool {
+	parsedA := net.ParseIP(a)
+	parsedB := net.ParseIP(b)
+	if parsedA == nil || parsedB == nil {
+		return a < b
+	}
+	return bytes.Compare(parsedA.To16(), parsedB.To16()) < 0
 }
 
 func (eit *EgressIPTracker) makeEmptyAllocation() (map[string][]string, map[string]bool) {
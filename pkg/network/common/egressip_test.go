@@ -110,6 +110,48 @@ func updateNetNamespaceEgress(eit *EgressIPTracker, ns *networkapi.NetNamespace)
 	eit.UpdateNetNamespaceEgress(ns)
 }
 
+func TestNamespaceEgressIPLimit(t *testing.T) {
+	eit, w := setupEgressIPTracker(t)
+	eit.SetNamespaceEgressIPLimit(1)
+
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.3",
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.4",
+		EgressIPs: []string{"172.17.0.101"},
+	})
+	w.flushChanges()
+
+	// Only the first requested IP is used; the second is over the limit
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     42,
+		EgressIPs: []string{"172.17.0.100", "172.17.0.101"},
+	})
+	err := w.assertChanges(
+		"claim 172.17.0.100 on 172.17.0.3 for namespace 42",
+		"namespace 42 via 172.17.0.100 on 172.17.0.3",
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Since the second IP was ignored, losing the first one drops the
+	// namespace rather than failing over
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.3",
+		EgressIPs: []string{},
+	})
+	err = w.assertChanges(
+		"release 172.17.0.100 on 172.17.0.3",
+		"namespace 42 dropped",
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
 func TestEgressIP(t *testing.T) {
 	eit, w := setupEgressIPTracker(t)
 
@@ -600,6 +642,98 @@ func TestDuplicateNodeEgressIPs(t *testing.T) {
 	}
 }
 
+type testEIPConflictHandler struct {
+	conflicts []string
+}
+
+func (h *testEIPConflictHandler) RecordNodeEgressIPConflict(egressIP string, nodeIPs []string) {
+	h.conflicts = append(h.conflicts, fmt.Sprintf("nodes %v claim %s", nodeIPs, egressIP))
+}
+
+func (h *testEIPConflictHandler) RecordNamespaceEgressIPConflict(egressIP string, vnids []uint32) {
+	h.conflicts = append(h.conflicts, fmt.Sprintf("namespaces %v claim %s", vnids, egressIP))
+}
+
+func TestEgressIPConflictHandler(t *testing.T) {
+	eit, w := setupEgressIPTracker(t)
+	handler := &testEIPConflictHandler{}
+	eit.SetConflictHandler(handler)
+
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     42,
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.3",
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	if len(handler.conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %v", handler.conflicts)
+	}
+
+	// Two nodes claiming the same IP
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.4",
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	if len(handler.conflicts) != 1 || handler.conflicts[0] != "nodes [172.17.0.3 172.17.0.4] claim 172.17.0.100" {
+		t.Fatalf("unexpected conflicts: %v", handler.conflicts)
+	}
+	handler.conflicts = nil
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.4",
+		EgressIPs: []string{},
+	})
+	w.flushChanges()
+
+	// Two namespaces claiming the same IP
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     43,
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	if len(handler.conflicts) != 1 || handler.conflicts[0] != "namespaces [42 43] claim 172.17.0.100" {
+		t.Fatalf("unexpected conflicts: %v", handler.conflicts)
+	}
+}
+
+func TestEgressIPFailoverOnNodeDelete(t *testing.T) {
+	eit, w := setupEgressIPTracker(t)
+
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     42,
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.3",
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	// Standby node also listing the IP double-books it, dropping traffic
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:    "172.17.0.4",
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	w.flushChanges()
+
+	// Deleting the owning node's HostSubnet re-homes the egress IP to the
+	// remaining node that still requests it
+	hs := &networkapi.HostSubnet{
+		HostIP:    "172.17.0.3",
+		EgressIPs: []string{"172.17.0.100"},
+	}
+	hs.Host = "node-3"
+	hs.Name = hs.Host
+	hs.UID = ktypes.UID(hs.Name)
+	eit.handleDeleteHostSubnet(hs)
+
+	err := w.assertChanges(
+		"claim 172.17.0.100 on 172.17.0.4 for namespace 42",
+		"namespace 42 via 172.17.0.100 on 172.17.0.4",
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
 func TestDuplicateNamespaceEgressIPs(t *testing.T) {
 	eit, w := setupEgressIPTracker(t)
 
@@ -1268,3 +1402,60 @@ func TestEgressCIDRAllocationOffline(t *testing.T) {
 	}
 	updateAllocations(eit, allocation)
 }
+
+func TestEgressIPAllocationPreference(t *testing.T) {
+	// Default (least-loaded) preference must balance IPs across eligible nodes
+	eit, _ := setupEgressIPTracker(t)
+
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:      "172.17.0.3",
+		EgressIPs:   []string{},
+		EgressCIDRs: []string{"172.17.0.0/24"},
+	})
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:      "172.17.0.4",
+		EgressIPs:   []string{},
+		EgressCIDRs: []string{"172.17.0.0/24"},
+	})
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     42,
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     43,
+		EgressIPs: []string{"172.17.0.101"},
+	})
+
+	allocation := eit.ReallocateEgressIPs()
+	if len(allocation["node-3"]) != 1 || len(allocation["node-4"]) != 1 {
+		t.Fatalf("Bad IP allocation: %#v", allocation)
+	}
+
+	// With PreferLowestNodeIP, all IPs land on the lowest-IP eligible node
+	eit, _ = setupEgressIPTracker(t)
+	eit.SetAllocationPreference(PreferLowestNodeIP)
+
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:      "172.17.0.3",
+		EgressIPs:   []string{},
+		EgressCIDRs: []string{"172.17.0.0/24"},
+	})
+	updateHostSubnetEgress(eit, &networkapi.HostSubnet{
+		HostIP:      "172.17.0.4",
+		EgressIPs:   []string{},
+		EgressCIDRs: []string{"172.17.0.0/24"},
+	})
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     42,
+		EgressIPs: []string{"172.17.0.100"},
+	})
+	updateNetNamespaceEgress(eit, &networkapi.NetNamespace{
+		NetID:     43,
+		EgressIPs: []string{"172.17.0.101"},
+	})
+
+	allocation = eit.ReallocateEgressIPs()
+	if len(allocation["node-3"]) != 2 || len(allocation["node-4"]) != 0 {
+		t.Fatalf("Bad IP allocation: %#v", allocation)
+	}
+}
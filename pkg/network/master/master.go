@@ -2,6 +2,7 @@ package master
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -10,6 +11,7 @@ import (
 	kapierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
@@ -49,6 +51,22 @@ type OsdnMaster struct {
 
 	// Holds Node IP used in creating host subnet for a node
 	hostSubnetNodeIPs map[ktypes.UID]string
+
+	// Serializes addNode calls per node so duplicate watch events cannot
+	// allocate two subnets for the same node
+	nodeAddLocks     map[string]*sync.Mutex
+	nodeAddLocksLock sync.Mutex
+
+	// Coalesces namespace deletions for bulk VNID revocation
+	deletedNamespaces chan string
+
+	// Removes subnets for nodes whose Deleted events were missed
+	nodeJanitor *nodeJanitor
+
+	// Closed by Stop to terminate background goroutines, which are tracked
+	// in wg so Stop can block until they have all exited
+	stopCh chan struct{}
+	wg     sync.WaitGroup
 }
 
 func Start(networkConfig openshiftcontrolplanev1.NetworkControllerConfig, networkClient networkclient.Interface,
@@ -67,6 +85,8 @@ func Start(networkConfig openshiftcontrolplanev1.NetworkControllerConfig, networ
 
 		subnetAllocatorMap: map[common.ClusterNetwork]*SubnetAllocator{},
 		hostSubnetNodeIPs:  map[ktypes.UID]string{},
+		nodeAddLocks:       map[string]*sync.Mutex{},
+		stopCh:             make(chan struct{}),
 	}
 
 	var err error
@@ -78,6 +98,9 @@ func Start(networkConfig openshiftcontrolplanev1.NetworkControllerConfig, networ
 	if err != nil {
 		return err
 	}
+	if err := master.networkInfo.CheckServiceNetworkOverlap(); err != nil {
+		return err
+	}
 	if len(clusterNetworkEntries) == 0 {
 		panic("No ClusterNetworks set in networkConfig; should have been defaulted in if not configured")
 	}
@@ -167,9 +190,27 @@ func Start(networkConfig openshiftcontrolplanev1.NetworkControllerConfig, networ
 	return nil
 }
 
+// runUntilStop runs fn every interval in a goroutine tracked by the master's
+// wait group, until Stop is called.
+func (master *OsdnMaster) runUntilStop(fn func(), interval time.Duration) {
+	master.wg.Add(1)
+	go func() {
+		defer master.wg.Done()
+		wait.Until(fn, interval, master.stopCh)
+	}()
+}
+
+// Stop terminates the master's background goroutines and blocks until they
+// have all exited. Informer event handlers are not unregistered; the informer
+// factories are owned by the caller.
+func (master *OsdnMaster) Stop() {
+	close(master.stopCh)
+	master.wg.Wait()
+}
+
 func (master *OsdnMaster) startSubSystems(pluginName string) {
 	// Wait for informer sync
-	if !cache.WaitForCacheSync(wait.NeverStop,
+	if !cache.WaitForCacheSync(master.stopCh,
 		master.nodeInformer.Informer().GetController().HasSynced,
 		master.namespaceInformer.Informer().GetController().HasSynced,
 		master.hostSubnetInformer.Informer().GetController().HasSynced,
@@ -197,6 +238,34 @@ func (master *OsdnMaster) startSubSystems(pluginName string) {
 	eim.Start(master.networkClient, master.hostSubnetInformer, master.netNamespaceInformer)
 }
 
+// ValidateNetworkConfig checks that the parsed network configuration could
+// serve a cluster of expectedNodeCount nodes, without writing anything: the
+// service network must not overlap any cluster network, every cluster network
+// must yield a working subnet allocator, and the combined subnet capacity
+// must cover the expected node count.
+func ValidateNetworkConfig(networkInfo *common.NetworkInfo, expectedNodeCount uint32) error {
+	errList := []error{}
+	var capacity uint64
+
+	for _, cn := range networkInfo.ClusterNetworks {
+		if cn.ClusterCIDR.Contains(networkInfo.ServiceNetwork.IP) || networkInfo.ServiceNetwork.Contains(cn.ClusterCIDR.IP) {
+			errList = append(errList, fmt.Errorf("cluster network %s overlaps service network %s", cn.ClusterCIDR.String(), networkInfo.ServiceNetwork.String()))
+		}
+		if _, err := newSubnetAllocator(cn.ClusterCIDR.String(), cn.HostSubnetLength); err != nil {
+			errList = append(errList, fmt.Errorf("cluster network %s with hostSubnetLength %d is unusable: %v", cn.ClusterCIDR.String(), cn.HostSubnetLength, err))
+			continue
+		}
+		maskSize, _ := cn.ClusterCIDR.Mask.Size()
+		capacity += 1 << (32 - uint32(maskSize) - cn.HostSubnetLength)
+	}
+
+	if capacity < uint64(expectedNodeCount) {
+		errList = append(errList, fmt.Errorf("cluster networks only provide %d host subnets, fewer than the expected %d nodes", capacity, expectedNodeCount))
+	}
+
+	return kerrors.NewAggregate(errList)
+}
+
 func (master *OsdnMaster) checkClusterNetworkAgainstLocalNetworks() error {
 	hostIPNets, _, err := netutils.GetHostIPNetworks([]string{tun0})
 	if err != nil {
@@ -2,6 +2,7 @@ package master
 
 import (
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -99,3 +100,89 @@ func Test_clusterNetworkChanged(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateNetworkConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		clusterNetworks   []networkapi.ClusterNetworkEntry
+		serviceNetwork    string
+		expectedNodeCount uint32
+		expectError       bool
+	}{
+		{
+			name:              "valid configuration",
+			clusterNetworks:   []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/14", HostSubnetLength: 9}},
+			serviceNetwork:    "172.30.0.0/16",
+			expectedNodeCount: 500,
+			expectError:       false,
+		},
+		{
+			name:              "service network overlaps cluster network",
+			clusterNetworks:   []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/14", HostSubnetLength: 9}},
+			serviceNetwork:    "10.128.0.0/16",
+			expectedNodeCount: 10,
+			expectError:       true,
+		},
+		{
+			name:              "insufficient capacity",
+			clusterNetworks:   []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/22", HostSubnetLength: 9}},
+			serviceNetwork:    "172.30.0.0/16",
+			expectedNodeCount: 100,
+			expectError:       true,
+		},
+		{
+			name:              "unusable host subnet length",
+			clusterNetworks:   []networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/14", HostSubnetLength: 0}},
+			serviceNetwork:    "172.30.0.0/16",
+			expectedNodeCount: 10,
+			expectError:       true,
+		},
+		{
+			name: "capacity summed across cluster networks",
+			clusterNetworks: []networkapi.ClusterNetworkEntry{
+				{CIDR: "10.128.0.0/23", HostSubnetLength: 9},
+				{CIDR: "10.130.0.0/23", HostSubnetLength: 9},
+			},
+			serviceNetwork:    "172.30.0.0/16",
+			expectedNodeCount: 2,
+			expectError:       false,
+		},
+	}
+
+	for _, test := range tests {
+		networkInfo, err := common.ParseNetworkInfo(test.clusterNetworks, test.serviceNetwork, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		err = ValidateNetworkConfig(networkInfo, test.expectedNodeCount)
+		if (err != nil) != test.expectError {
+			t.Fatalf("%s: unexpected result: %v", test.name, err)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	master := &OsdnMaster{
+		stopCh:            make(chan struct{}),
+		deletedNamespaces: make(chan string, revokeBatchMax),
+	}
+
+	master.wg.Add(1)
+	go func() {
+		defer master.wg.Done()
+		master.revokeVNIDsLoop()
+	}()
+	master.runUntilStop(func() {}, time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		master.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after background goroutines were signalled")
+	}
+}
@@ -0,0 +1,59 @@
+package master
+
+// AllocationMetrics is a pluggable sink for subnet and netid pool
+// observability, so deployments can watch how close the cluster is to
+// exhausting either range. It is an interface rather than Prometheus
+// collectors to avoid forcing a metrics dependency into this package;
+// implementations are expected to maintain their own counters or gauges.
+type AllocationMetrics interface {
+	// SubnetAllocated is called when a host subnet is handed out.
+	SubnetAllocated()
+	// SubnetReleased is called when a host subnet is returned to the pool.
+	SubnetReleased()
+	// VNIDAllocated is called when a netid is handed out.
+	VNIDAllocated()
+	// VNIDReleased is called when a netid is returned to the pool.
+	VNIDReleased()
+	// AllocationFailed is called when a subnet or netid could not be
+	// allocated, e.g. because the pool is exhausted.
+	AllocationFailed()
+}
+
+// allocationMetrics is the installed sink; nil disables collection.
+var allocationMetrics AllocationMetrics
+
+// SetAllocationMetrics installs the metrics sink consulted by the subnet and
+// vnid allocators. Pass nil to disable collection.
+func SetAllocationMetrics(m AllocationMetrics) {
+	allocationMetrics = m
+}
+
+func recordSubnetAllocated() {
+	if allocationMetrics != nil {
+		allocationMetrics.SubnetAllocated()
+	}
+}
+
+func recordSubnetReleased() {
+	if allocationMetrics != nil {
+		allocationMetrics.SubnetReleased()
+	}
+}
+
+func recordVNIDAllocated() {
+	if allocationMetrics != nil {
+		allocationMetrics.VNIDAllocated()
+	}
+}
+
+func recordVNIDReleased() {
+	if allocationMetrics != nil {
+		allocationMetrics.VNIDReleased()
+	}
+}
+
+func recordAllocationFailed() {
+	if allocationMetrics != nil {
+		allocationMetrics.AllocationFailed()
+	}
+}
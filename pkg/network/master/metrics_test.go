@@ -0,0 +1,56 @@
+package master
+
+import (
+	"testing"
+)
+
+type countingAllocationMetrics struct {
+	subnetsAllocated int
+	subnetsReleased  int
+	vnidsAllocated   int
+	vnidsReleased    int
+	failures         int
+}
+
+func (m *countingAllocationMetrics) SubnetAllocated()  { m.subnetsAllocated++ }
+func (m *countingAllocationMetrics) SubnetReleased()   { m.subnetsReleased++ }
+func (m *countingAllocationMetrics) VNIDAllocated()    { m.vnidsAllocated++ }
+func (m *countingAllocationMetrics) VNIDReleased()     { m.vnidsReleased++ }
+func (m *countingAllocationMetrics) AllocationFailed() { m.failures++ }
+
+func TestAllocationMetrics(t *testing.T) {
+	metrics := &countingAllocationMetrics{}
+	SetAllocationMetrics(metrics)
+	defer SetAllocationMetrics(nil)
+
+	// VNID allocate/release cycles move the counters
+	vmap, err := newMasterVNIDMapWithRange(true, 100, 101)
+	checkNoErr(t, err)
+	_, _, err = vmap.allocateNetID("alpha")
+	checkNoErr(t, err)
+	_, _, err = vmap.allocateNetID("bravo")
+	checkNoErr(t, err)
+	if metrics.vnidsAllocated != 2 {
+		t.Fatalf("Expected 2 vnid allocations, got %d", metrics.vnidsAllocated)
+	}
+
+	// An admin namespace uses the global vnid and is not counted
+	_, _, err = vmap.allocateNetID("default")
+	checkNoErr(t, err)
+	if metrics.vnidsAllocated != 2 {
+		t.Fatalf("Expected admin namespace to be uncounted, got %d", metrics.vnidsAllocated)
+	}
+
+	// Exhaustion is recorded as a failure
+	_, _, err = vmap.allocateNetID("charlie")
+	checkErr(t, err)
+	if metrics.failures != 1 {
+		t.Fatalf("Expected 1 allocation failure, got %d", metrics.failures)
+	}
+
+	checkNoErr(t, vmap.releaseNetID("alpha"))
+	checkNoErr(t, vmap.releaseNetID("bravo"))
+	if metrics.vnidsReleased != 2 {
+		t.Fatalf("Expected 2 vnid releases, got %d", metrics.vnidsReleased)
+	}
+}
@@ -0,0 +1,115 @@
+package master
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	networkapi "github.com/openshift/api/network/v1"
+)
+
+const (
+	// defaultNodeJanitorInterval is how often the janitor scans for stale nodes
+	defaultNodeJanitorInterval = 1 * time.Minute
+	// defaultNodeGracePeriod is how long a node must remain unseen before its
+	// subnet is removed; long enough that temporarily unreachable nodes are
+	// not deleted prematurely.
+	defaultNodeGracePeriod = 5 * time.Minute
+)
+
+// nodeJanitor tracks when each node was last seen by watchNodes and reports
+// nodes that have been gone longer than the grace period, so subnets left
+// behind by Deleted events missed while the master was down get cleaned up.
+type nodeJanitor struct {
+	sync.Mutex
+
+	gracePeriod time.Duration
+	lastSeen    map[string]time.Time
+}
+
+func newNodeJanitor(gracePeriod time.Duration) *nodeJanitor {
+	if gracePeriod <= 0 {
+		gracePeriod = defaultNodeGracePeriod
+	}
+	return &nodeJanitor{
+		gracePeriod: gracePeriod,
+		lastSeen:    make(map[string]time.Time),
+	}
+}
+
+func (nj *nodeJanitor) markSeen(nodeName string, now time.Time) {
+	nj.Lock()
+	defer nj.Unlock()
+
+	nj.lastSeen[nodeName] = now
+}
+
+func (nj *nodeJanitor) forget(nodeName string) {
+	nj.Lock()
+	defer nj.Unlock()
+
+	delete(nj.lastSeen, nodeName)
+}
+
+// staleNodes returns the tracked nodes that have not been seen within the
+// grace period before now.
+func (nj *nodeJanitor) staleNodes(now time.Time) []string {
+	nj.Lock()
+	defer nj.Unlock()
+
+	var stale []string
+	for nodeName, seen := range nj.lastSeen {
+		if now.Sub(seen) > nj.gracePeriod {
+			stale = append(stale, nodeName)
+		}
+	}
+	return stale
+}
+
+//--------------------- Master methods ----------------------
+
+func (master *OsdnMaster) startNodeJanitor() error {
+	master.nodeJanitor = newNodeJanitor(defaultNodeGracePeriod)
+
+	// Seed last-seen times from existing HostSubnets so that subnets whose
+	// nodes were deleted while the master was down are eventually reclaimed.
+	subnets, err := master.networkClient.Network().HostSubnets().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, sn := range subnets.Items {
+		if len(sn.Annotations[networkapi.NodeUIDAnnotation]) > 0 {
+			master.nodeJanitor.markSeen(sn.Name, now)
+		}
+	}
+
+	master.runUntilStop(master.runNodeJanitor, defaultNodeJanitorInterval)
+	return nil
+}
+
+func (master *OsdnMaster) runNodeJanitor() {
+	for _, nodeName := range master.nodeJanitor.staleNodes(time.Now()) {
+		// The node may have been seen by the informer without generating an
+		// event we track; double-check before deleting.
+		if _, err := master.nodeInformer.Lister().Get(nodeName); err == nil {
+			master.nodeJanitor.markSeen(nodeName, time.Now())
+			continue
+		} else if !kerrs.IsNotFound(err) {
+			utilruntime.HandleError(fmt.Errorf("Error checking stale node %s: %v", nodeName, err))
+			continue
+		}
+
+		glog.Infof("Node %s has been absent for more than %v; removing its subnet", nodeName, master.nodeJanitor.gracePeriod)
+		master.nodeJanitor.forget(nodeName)
+		if err := master.deleteNode(nodeName); err != nil {
+			utilruntime.HandleError(fmt.Errorf("Error deleting stale node %s: %v", nodeName, err))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package master
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodeJanitorTiming(t *testing.T) {
+	nj := newNodeJanitor(5 * time.Minute)
+	start := time.Now()
+
+	nj.markSeen("alpha", start)
+	nj.markSeen("bravo", start.Add(3*time.Minute))
+
+	// Within the grace period nothing is stale
+	if stale := nj.staleNodes(start.Add(4 * time.Minute)); len(stale) != 0 {
+		t.Fatalf("expected no stale nodes, got %v", stale)
+	}
+
+	// Just past alpha's grace period only alpha is stale
+	stale := nj.staleNodes(start.Add(6 * time.Minute))
+	if len(stale) != 1 || stale[0] != "alpha" {
+		t.Fatalf("expected [alpha], got %v", stale)
+	}
+
+	// Re-seeing a node resets its timer
+	nj.markSeen("alpha", start.Add(6*time.Minute))
+	if stale := nj.staleNodes(start.Add(7 * time.Minute)); len(stale) != 0 {
+		t.Fatalf("expected no stale nodes, got %v", stale)
+	}
+
+	// A forgotten node is never reported stale
+	nj.forget("bravo")
+	stale = nj.staleNodes(start.Add(20 * time.Minute))
+	if len(stale) != 1 || stale[0] != "alpha" {
+		t.Fatalf("expected [alpha], got %v", stale)
+	}
+}
+
+func TestNodeJanitorDefaultGracePeriod(t *testing.T) {
+	nj := newNodeJanitor(0)
+	if nj.gracePeriod != defaultNodeGracePeriod {
+		t.Fatalf("expected default grace period %v, got %v", defaultNodeGracePeriod, nj.gracePeriod)
+	}
+}
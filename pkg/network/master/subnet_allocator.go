@@ -3,6 +3,7 @@ package master
 import (
 	"encoding/binary"
 	"fmt"
+	"math/rand"
 	"net"
 	"sync"
 
@@ -12,6 +13,19 @@ import (
 
 var ErrSubnetAllocatorFull = fmt.Errorf("No subnets available.")
 
+// SubnetAllocationStrategy selects the order in which free subnets are
+// handed out.
+type SubnetAllocationStrategy string
+
+const (
+	// SequentialAllocation hands out subnets in order, continuing after the
+	// most recently allocated one. This is the default.
+	SequentialAllocation SubnetAllocationStrategy = "sequential"
+	// RandomAllocation scans for a free subnet from a random starting point,
+	// reducing reuse collisions when nodes churn.
+	RandomAllocation SubnetAllocationStrategy = "random"
+)
+
 type SubnetAllocator struct {
 	network    *net.IPNet
 	hostBits   uint32
@@ -21,10 +35,22 @@ type SubnetAllocator struct {
 	rightMask  uint32
 	next       uint32
 	allocMap   map[string]bool
-	mutex      sync.Mutex
+	// Subnets reserved for infrastructure; never returned by allocateNetwork
+	excluded []*net.IPNet
+	// The zero value behaves like SequentialAllocation
+	strategy SubnetAllocationStrategy
+	mutex    sync.Mutex
 }
 
 func newSubnetAllocator(network string, hostBits uint32) (*SubnetAllocator, error) {
+	return newSubnetAllocatorWithExcludedSubnets(network, hostBits, nil)
+}
+
+// newSubnetAllocatorWithExcludedSubnets works like newSubnetAllocator but
+// additionally reserves the given CIDRs so that allocateNetwork will never
+// return a subnet overlapping any of them. Each excluded CIDR must fall
+// within the allocator's network.
+func newSubnetAllocatorWithExcludedSubnets(network string, hostBits uint32, excludeSubnets []string) (*SubnetAllocator, error) {
 	_, netIP, err := net.ParseCIDR(network)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse network address: %q", network)
@@ -65,6 +91,18 @@ func newSubnetAllocator(network string, hostBits uint32) (*SubnetAllocator, erro
 		rightMask = 0
 	}
 
+	var excluded []*net.IPNet
+	for _, exclude := range excludeSubnets {
+		_, excludeIPNet, err := net.ParseCIDR(exclude)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse excluded subnet: %q", exclude)
+		}
+		if !netIP.Contains(excludeIPNet.IP) {
+			return nil, fmt.Errorf("excluded subnet %v doesn't belong to network %v", excludeIPNet, netIP)
+		}
+		excluded = append(excluded, excludeIPNet)
+	}
+
 	return &SubnetAllocator{
 		network:    netIP,
 		hostBits:   hostBits,
@@ -74,9 +112,36 @@ func newSubnetAllocator(network string, hostBits uint32) (*SubnetAllocator, erro
 		rightMask:  rightMask,
 		next:       0,
 		allocMap:   make(map[string]bool),
+		excluded:   excluded,
 	}, nil
 }
 
+// newSubnetAllocatorWithStrategy works like newSubnetAllocatorWithExcludedSubnets
+// but additionally selects the allocation order.
+func newSubnetAllocatorWithStrategy(network string, hostBits uint32, excludeSubnets []string, strategy SubnetAllocationStrategy) (*SubnetAllocator, error) {
+	switch strategy {
+	case SequentialAllocation, RandomAllocation, "":
+	default:
+		return nil, fmt.Errorf("unknown subnet allocation strategy: %q", strategy)
+	}
+	sna, err := newSubnetAllocatorWithExcludedSubnets(network, hostBits, excludeSubnets)
+	if err != nil {
+		return nil, err
+	}
+	sna.strategy = strategy
+	return sna, nil
+}
+
+// isExcluded returns true if the given subnet overlaps any excluded subnet.
+func (sna *SubnetAllocator) isExcluded(ipNet *net.IPNet) bool {
+	for _, excludeIPNet := range sna.excluded {
+		if excludeIPNet.Contains(ipNet.IP) || ipNet.Contains(excludeIPNet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
 func (sna *SubnetAllocator) markAllocatedNetwork(ipNet *net.IPNet) error {
 	sna.mutex.Lock()
 	defer sna.mutex.Unlock()
@@ -103,13 +168,21 @@ func (sna *SubnetAllocator) allocateNetwork() (*net.IPNet, error) {
 	numSubnetBits = 32 - uint32(netMaskSize) - sna.hostBits
 	numSubnets = 1 << numSubnetBits
 
+	start := sna.next
+	if sna.strategy == RandomAllocation {
+		start = uint32(rand.Intn(int(numSubnets)))
+	}
+
 	var i uint32
 	for i = 0; i < numSubnets; i++ {
-		n := (i + sna.next) % numSubnets
+		n := (i + start) % numSubnets
 		shifted := n << sna.hostBits
 		ipu := baseipu | ((shifted << sna.leftShift) & sna.leftMask) | ((shifted >> sna.rightShift) & sna.rightMask)
 		genIp := Uint32ToIP(ipu)
 		genSubnet := &net.IPNet{IP: genIp, Mask: net.CIDRMask(int(numSubnetBits)+netMaskSize, 32)}
+		if sna.isExcluded(genSubnet) {
+			continue
+		}
 		if !sna.allocMap[genSubnet.String()] {
 			sna.allocMap[genSubnet.String()] = true
 			sna.next = n + 1
@@ -128,6 +201,9 @@ func (sna *SubnetAllocator) releaseNetwork(ipnet *net.IPNet) error {
 	if !sna.network.Contains(ipnet.IP) {
 		return fmt.Errorf("provided subnet %v doesn't belong to the network %v.", ipnet, sna.network)
 	}
+	if sna.isExcluded(ipnet) {
+		return fmt.Errorf("provided subnet %v is excluded from allocation.", ipnet)
+	}
 
 	ipnetStr := ipnet.String()
 	if !sna.allocMap[ipnetStr] {
@@ -198,9 +274,11 @@ func (master *OsdnMaster) allocateNetwork(nodeName string) (string, error) {
 			utilruntime.HandleError(fmt.Errorf("Error allocating network from subnet: %v", possibleSubnet))
 			continue
 		} else {
+			recordSubnetAllocated()
 			return sn.String(), nil
 		}
 	}
+	recordAllocationFailed()
 	return "", fmt.Errorf("error allocating network for node %s: %v", nodeName, err)
 }
 
@@ -212,6 +290,7 @@ func (master *OsdnMaster) releaseNetwork(subnet string) error {
 	if err = sa.releaseNetwork(ipnet); err != nil {
 		return err
 	}
+	recordSubnetReleased()
 	return nil
 }
 
@@ -165,6 +165,59 @@ func TestAllocateSubnetNoSubnetBits(t *testing.T) {
 	}
 }
 
+func TestAllocateSubnetWithExcludedSubnets(t *testing.T) {
+	sna, err := newSubnetAllocatorWithExcludedSubnets("10.1.0.0/16", 14, []string{"10.1.128.0/18"})
+	if err != nil {
+		t.Fatal("Failed to initialize subnet allocator: ", err)
+	}
+
+	// Only three of the four /18s are allocatable
+	for n := 0; n < 3; n++ {
+		sn, err := sna.allocateNetwork()
+		if err != nil {
+			t.Fatal("Failed to allocate network: ", err)
+		}
+		if sn.String() == "10.1.128.0/18" {
+			t.Fatalf("Unexpectedly allocated excluded subnet (n=%d, sn=%s)", n, sn.String())
+		}
+	}
+
+	// Exhaustion must not hand out the excluded subnet
+	if sn, err := sna.allocateNetwork(); err == nil {
+		t.Fatalf("Unexpectedly succeeded in allocating network (sn=%s)", sn.String())
+	}
+
+	// Excluded subnets cannot be released back into the pool
+	_, excludedSn, err := net.ParseCIDR("10.1.128.0/18")
+	if err != nil {
+		t.Fatal("Failed to parse given network: ", err)
+	}
+	if err := sna.releaseNetwork(excludedSn); err == nil {
+		t.Fatalf("Unexpectedly succeeded in releasing excluded subnet (sn=%s)", excludedSn.String())
+	}
+
+	// An allocator whose entire space is excluded must fail cleanly
+	sna, err = newSubnetAllocatorWithExcludedSubnets("10.2.0.0/16", 14, []string{"10.2.0.0/16"})
+	if err != nil {
+		t.Fatal("Failed to initialize subnet allocator: ", err)
+	}
+	if sn, err := sna.allocateNetwork(); err == nil {
+		t.Fatalf("Unexpectedly succeeded in allocating network (sn=%s)", sn.String())
+	}
+}
+
+func TestExcludedSubnetsOutsideNetwork(t *testing.T) {
+	_, err := newSubnetAllocatorWithExcludedSubnets("10.1.0.0/16", 8, []string{"10.2.0.0/24"})
+	if err == nil {
+		t.Fatal("Unexpectedly succeeded in initializing subnet allocator")
+	}
+
+	_, err = newSubnetAllocatorWithExcludedSubnets("10.1.0.0/16", 8, []string{"bogus"})
+	if err == nil {
+		t.Fatal("Unexpectedly succeeded in initializing subnet allocator")
+	}
+}
+
 func TestAllocateSubnetInvalidHostBitsOrCIDR(t *testing.T) {
 	_, err := newSubnetAllocator("10.1.0.0/16", 18)
 	if err == nil {
@@ -265,6 +318,52 @@ func TestAllocateReleaseSubnet(t *testing.T) {
 	}
 }
 
+func TestAllocateSubnetRandomStrategy(t *testing.T) {
+	sna, err := newSubnetAllocatorWithStrategy("10.1.0.0/16", 8, nil, RandomAllocation)
+	if err != nil {
+		t.Fatal("Failed to initialize subnet allocator: ", err)
+	}
+
+	// Random allocation must still cover the entire range without
+	// handing out any subnet twice.
+	allocated := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		sn, err := sna.allocateNetwork()
+		if err != nil {
+			t.Fatalf("Failed to allocate network %d: %v", i, err)
+		}
+		if allocated[sn.String()] {
+			t.Fatalf("Subnet %s allocated twice", sn.String())
+		}
+		allocated[sn.String()] = true
+	}
+
+	sn, err := sna.allocateNetwork()
+	if err == nil {
+		t.Fatalf("Unexpectedly succeeded in allocating network (sn=%s)", sn.String())
+	}
+
+	// Released subnets become allocatable again
+	_, releaseSn, _ := net.ParseCIDR("10.1.17.0/24")
+	if err := sna.releaseNetwork(releaseSn); err != nil {
+		t.Fatalf("Failed to release the subnet (releaseSn=%s): %v", releaseSn.String(), err)
+	}
+	sn, err = sna.allocateNetwork()
+	if err != nil {
+		t.Fatal("Failed to allocate network: ", err)
+	}
+	if sn.String() != releaseSn.String() {
+		t.Fatalf("Did not get expected subnet (sn=%s)", sn.String())
+	}
+}
+
+func TestAllocateSubnetUnknownStrategy(t *testing.T) {
+	_, err := newSubnetAllocatorWithStrategy("10.1.0.0/16", 8, nil, SubnetAllocationStrategy("bogus"))
+	if err == nil {
+		t.Fatal("Unexpectedly succeeded in creating allocator with unknown strategy")
+	}
+}
+
 func TestIPUint32Conversion(t *testing.T) {
 	ip := net.ParseIP("10.1.2.3")
 	if ip == nil {
@@ -3,13 +3,17 @@ package master
 import (
 	"fmt"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 
 	kapi "k8s.io/api/core/v1"
 	kerrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilwait "k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/util/retry"
 
@@ -18,11 +22,30 @@ import (
 	"github.com/openshift/origin/pkg/network/common"
 )
 
+// createSubnetBackoff bounds the retries around HostSubnet creation so a
+// transient etcd hiccup does not leave a node without a subnet until its next
+// watch event.
+var createSubnetBackoff = utilwait.Backoff{
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Steps:    4,
+}
+
 func (master *OsdnMaster) startSubnetMaster() error {
+	// Reap subnets for nodes deleted while the master was down before the
+	// allocators are seeded, so their networks are never marked in use.
+	if err := master.reconcileStaleSubnets(); err != nil {
+		utilruntime.HandleError(fmt.Errorf("Error reconciling stale subnets: %v", err))
+	}
+
 	if err := master.initSubnetAllocators(); err != nil {
 		return err
 	}
 
+	if err := master.startNodeJanitor(); err != nil {
+		return err
+	}
+
 	master.watchNodes()
 	master.watchSubnets()
 
@@ -36,6 +59,7 @@ func (master *OsdnMaster) watchNodes() {
 
 func (master *OsdnMaster) handleAddOrUpdateNode(obj, _ interface{}, eventType watch.EventType) {
 	node := obj.(*kapi.Node)
+	master.nodeJanitor.markSeen(node.Name, time.Now())
 
 	nodeIP := getNodeInternalIP(node)
 	if len(nodeIP) == 0 {
@@ -62,6 +86,7 @@ func (master *OsdnMaster) handleAddOrUpdateNode(obj, _ interface{}, eventType wa
 func (master *OsdnMaster) handleDeleteNode(obj interface{}) {
 	node := obj.(*kapi.Node)
 	glog.V(5).Infof("Watch %s event for Node %q", watch.Deleted, node.Name)
+	master.nodeJanitor.forget(node.Name)
 
 	if _, exists := master.hostSubnetNodeIPs[node.UID]; !exists {
 		return
@@ -79,6 +104,13 @@ func (master *OsdnMaster) handleDeleteNode(obj interface{}) {
 // Creates or updates a HostSubnet if needed
 // Returns the IP address used for hostsubnet (either the preferred or one from the otherValidAddresses) and any error
 func (master *OsdnMaster) addNode(nodeName string, nodeUID string, nodeIP string, hsAnnotations map[string]string) (string, error) {
+	// Serialize per node: a duplicate Added event racing with the first one
+	// would otherwise see no existing HostSubnet and allocate a second subnet.
+	// The loser of the race finds the created subnet below and returns early.
+	lock := master.nodeAddLock(nodeName)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Validate node IP before proceeding
 	if err := master.networkInfo.ValidateNodeIP(nodeIP); err != nil {
 		return "", err
@@ -123,17 +155,41 @@ func (master *OsdnMaster) addNode(nodeName string, nodeUID string, nodeIP string
 		HostIP:     nodeIP,
 		Subnet:     network,
 	}
-	sub, err = master.networkClient.Network().HostSubnets().Create(sub)
+	// Retry transient write failures with backoff; on permanent failure the
+	// allocated network is released so it is not leaked.
+	var lastErr error
+	err = utilwait.ExponentialBackoff(createSubnetBackoff, func() (bool, error) {
+		created, err := master.networkClient.Network().HostSubnets().Create(sub)
+		if err != nil {
+			lastErr = err
+			glog.V(4).Infof("Error creating HostSubnet for node %s, will retry: %v", nodeName, err)
+			return false, nil
+		}
+		sub = created
+		return true, nil
+	})
 	if err != nil {
 		if er := master.releaseNetwork(network); er != nil {
 			utilruntime.HandleError(er)
 		}
-		return "", fmt.Errorf("error allocating subnet for node %q: %v", nodeName, err)
+		return "", fmt.Errorf("error allocating subnet for node %q: %v", nodeName, lastErr)
 	}
 	glog.Infof("Created HostSubnet %s", common.HostSubnetToString(sub))
 	return nodeIP, nil
 }
 
+func (master *OsdnMaster) nodeAddLock(nodeName string) *sync.Mutex {
+	master.nodeAddLocksLock.Lock()
+	defer master.nodeAddLocksLock.Unlock()
+
+	lock, ok := master.nodeAddLocks[nodeName]
+	if !ok {
+		lock = &sync.Mutex{}
+		master.nodeAddLocks[nodeName] = lock
+	}
+	return lock
+}
+
 func (master *OsdnMaster) deleteNode(nodeName string) error {
 	subInfo := nodeName
 	// If create and delete events for the same node are called in quick succession,
@@ -147,6 +203,11 @@ func (master *OsdnMaster) deleteNode(nodeName string) error {
 	}
 
 	glog.Infof("Deleted HostSubnet %s", subInfo)
+
+	master.nodeAddLocksLock.Lock()
+	delete(master.nodeAddLocks, nodeName)
+	master.nodeAddLocksLock.Unlock()
+
 	return nil
 }
 
@@ -250,6 +311,24 @@ func (master *OsdnMaster) handleDeleteSubnet(obj interface{}) {
 	}
 }
 
+// reconcileStaleSubnets runs reconcileHostSubnet over every HostSubnet, so
+// subnets whose nodes were deleted while the master was offline are removed
+// at startup instead of lingering until their next watch event.
+func (master *OsdnMaster) reconcileStaleSubnets() error {
+	subnets, err := master.networkClient.Network().HostSubnets().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing subnets: %v", err)
+	}
+
+	errList := []error{}
+	for i := range subnets.Items {
+		if err := master.reconcileHostSubnet(&subnets.Items[i]); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	return kerrors.NewAggregate(errList)
+}
+
 // reconcileHostSubnet verifies and corrects the state of the hostsubnet.
 // Because openshift watches on events to keep hostsubnets and nodes in the correct state, missing an event
 // can cause orphaned or unusable hostsubnets to stick around.
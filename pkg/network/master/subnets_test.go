@@ -0,0 +1,180 @@
+package master
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	utilwait "k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	kfake "k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+
+	networkapi "github.com/openshift/api/network/v1"
+	networkfake "github.com/openshift/client-go/network/clientset/versioned/fake"
+	"github.com/openshift/origin/pkg/network/common"
+)
+
+func TestReconcileStaleSubnets(t *testing.T) {
+	node := &kapi.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", UID: "uid-1"},
+	}
+	validSubnet := &networkapi.HostSubnet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "node-1",
+			Annotations: map[string]string{networkapi.NodeUIDAnnotation: "uid-1"},
+		},
+		Host:   "node-1",
+		HostIP: "10.0.0.1",
+		Subnet: "10.128.0.0/23",
+	}
+	orphanSubnet := &networkapi.HostSubnet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "node-2",
+			Annotations: map[string]string{networkapi.NodeUIDAnnotation: "uid-2"},
+		},
+		Host:   "node-2",
+		HostIP: "10.0.0.2",
+		Subnet: "10.128.2.0/23",
+	}
+	// Subnets without a node UID annotation belong to F5 and must be kept
+	f5Subnet := &networkapi.HostSubnet{
+		ObjectMeta: metav1.ObjectMeta{Name: "f5-subnet"},
+		Host:       "f5-subnet",
+		HostIP:     "10.0.0.3",
+		Subnet:     "10.128.4.0/23",
+	}
+
+	kClient := kfake.NewSimpleClientset(node)
+	networkClient := networkfake.NewSimpleClientset(validSubnet, orphanSubnet, f5Subnet)
+	master := &OsdnMaster{
+		kClient:       kClient,
+		networkClient: networkClient,
+		nodeInformer:  informers.NewSharedInformerFactory(kClient, 0).Core().V1().Nodes(),
+	}
+
+	if err := master.reconcileStaleSubnets(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	subnets, err := networkClient.Network().HostSubnets().List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remaining := map[string]bool{}
+	for _, hs := range subnets.Items {
+		remaining[hs.Name] = true
+	}
+	if !remaining["node-1"] {
+		t.Errorf("subnet for existing node was deleted")
+	}
+	if remaining["node-2"] {
+		t.Errorf("subnet for deleted node was not reclaimed")
+	}
+	if !remaining["f5-subnet"] {
+		t.Errorf("subnet not backed by a node was deleted")
+	}
+}
+
+func newTestSubnetMaster(t *testing.T, networkClient *networkfake.Clientset) *OsdnMaster {
+	networkInfo, err := common.ParseNetworkInfo([]networkapi.ClusterNetworkEntry{{CIDR: "10.128.0.0/16", HostSubnetLength: 8}}, "172.30.0.0/16", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	master := &OsdnMaster{
+		networkClient:      networkClient,
+		networkInfo:        networkInfo,
+		subnetAllocatorMap: map[common.ClusterNetwork]*SubnetAllocator{},
+		hostSubnetNodeIPs:  map[ktypes.UID]string{},
+		nodeAddLocks:       map[string]*sync.Mutex{},
+	}
+	if err := master.initSubnetAllocators(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return master
+}
+
+func TestAddNodeDuplicateEvents(t *testing.T) {
+	networkClient := networkfake.NewSimpleClientset()
+	var createsLock sync.Mutex
+	creates := 0
+	networkClient.PrependReactor("create", "hostsubnets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		createsLock.Lock()
+		creates++
+		createsLock.Unlock()
+		return false, nil, nil
+	})
+	master := newTestSubnetMaster(t, networkClient)
+
+	// Concurrent duplicate Added events must allocate only one subnet
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := master.addNode("node-1", "uid-1", "172.17.0.2", nil); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if creates != 1 {
+		t.Fatalf("expected a single HostSubnet create, got %d", creates)
+	}
+	next, err := master.allocateNetwork("node-2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next != "10.128.1.0/24" {
+		t.Fatalf("expected only one subnet to be allocated for node-1, next allocation got %s", next)
+	}
+}
+
+func TestAddNodeRetriesSubnetCreation(t *testing.T) {
+	origBackoff := createSubnetBackoff
+	createSubnetBackoff = utilwait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+	defer func() { createSubnetBackoff = origBackoff }()
+
+	// Transient failures are retried until the create succeeds
+	networkClient := networkfake.NewSimpleClientset()
+	failures := 2
+	networkClient.PrependReactor("create", "hostsubnets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, fmt.Errorf("transient write error")
+		}
+		return false, nil, nil
+	})
+	master := newTestSubnetMaster(t, networkClient)
+
+	if _, err := master.addNode("node-1", "uid-1", "172.17.0.2", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := networkClient.Network().HostSubnets().Get("node-1", metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected HostSubnet to be created: %v", err)
+	}
+
+	// Permanent failures release the allocated network instead of leaking it
+	networkClient = networkfake.NewSimpleClientset()
+	networkClient.PrependReactor("create", "hostsubnets", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("permanent write error")
+	})
+	master = newTestSubnetMaster(t, networkClient)
+
+	if _, err := master.addNode("node-2", "uid-2", "172.17.0.3", nil); err == nil {
+		t.Fatalf("expected error for permanently failing create")
+	}
+	first, err := master.allocateNetwork("node-3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != "10.128.0.0/24" {
+		t.Fatalf("expected failed allocation to be released, next allocation got %s", first)
+	}
+}
@@ -3,11 +3,14 @@ package master
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 
 	kapi "k8s.io/api/core/v1"
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/watch"
@@ -30,18 +33,61 @@ type masterVNIDMap struct {
 	allowRenumbering bool
 }
 
+// vnidRangeMin and vnidRangeMax are the netid range consulted when the vnid
+// map is created at Start; see SetVNIDRange.
+var vnidRangeMin, vnidRangeMax uint32 = network.MinVNID, network.MaxVNID
+
+// SetVNIDRange restricts netid allocation to [minVNID, maxVNID], so operators
+// can reserve a block of low VNIDs for special-purpose namespaces. It must be
+// called before Start. The range must lie within the values allowed by netid
+// validation.
+func SetVNIDRange(minVNID, maxVNID uint32) error {
+	if err := validateVNIDRange(minVNID, maxVNID); err != nil {
+		return err
+	}
+	vnidRangeMin, vnidRangeMax = minVNID, maxVNID
+	return nil
+}
+
+func validateVNIDRange(minVNID, maxVNID uint32) error {
+	if minVNID < network.MinVNID {
+		return fmt.Errorf("minVNID %d must be greater than or equal to %d", minVNID, network.MinVNID)
+	}
+	if maxVNID > network.MaxVNID {
+		return fmt.Errorf("maxVNID %d must be less than or equal to %d", maxVNID, network.MaxVNID)
+	}
+	if minVNID > maxVNID {
+		return fmt.Errorf("minVNID %d must not be greater than maxVNID %d", minVNID, maxVNID)
+	}
+	return nil
+}
+
 func newMasterVNIDMap(allowRenumbering bool) *masterVNIDMap {
-	netIDRange, err := pnetid.NewNetIDRange(network.MinVNID, network.MaxVNID)
+	vmap, err := newMasterVNIDMapWithRange(allowRenumbering, vnidRangeMin, vnidRangeMax)
 	if err != nil {
 		panic(err)
 	}
+	return vmap
+}
+
+// newMasterVNIDMapWithRange creates a vnid map allocating netids from
+// [minVNID, maxVNID]. The range must lie within the values allowed by netid
+// validation.
+func newMasterVNIDMapWithRange(allowRenumbering bool, minVNID, maxVNID uint32) (*masterVNIDMap, error) {
+	if err := validateVNIDRange(minVNID, maxVNID); err != nil {
+		return nil, err
+	}
+	netIDRange, err := pnetid.NewNetIDRange(minVNID, maxVNID)
+	if err != nil {
+		return nil, err
+	}
 
 	return &masterVNIDMap{
 		netIDManager:     pnetid.NewInMemory(netIDRange),
 		adminNamespaces:  sets.NewString(metav1.NamespaceDefault),
 		ids:              make(map[string]uint32),
 		allowRenumbering: allowRenumbering,
-	}
+	}, nil
 }
 
 func (vmap *masterVNIDMap) getVNID(name string) (uint32, bool) {
@@ -107,8 +153,10 @@ func (vmap *masterVNIDMap) allocateNetID(nsName string) (uint32, bool, error) {
 		var err error
 		netid, err = vmap.netIDManager.AllocateNext()
 		if err != nil {
+			recordAllocationFailed()
 			return 0, exists, err
 		}
+		recordVNIDAllocated()
 	}
 
 	vmap.setVNID(nsName, netid)
@@ -134,6 +182,7 @@ func (vmap *masterVNIDMap) releaseNetID(nsName string) error {
 		if err := vmap.netIDManager.Release(netid); err != nil {
 			return fmt.Errorf("error while releasing netid %d for namespace %q, %v", netid, nsName, err)
 		}
+		recordVNIDReleased()
 		glog.Infof("Released netid %d for namespace %q", netid, nsName)
 	} else {
 		glog.V(5).Infof("netid %d for namespace %q is still in use", netid, nsName)
@@ -173,8 +222,10 @@ func (vmap *masterVNIDMap) updateNetID(nsName string, action networkapihelpers.P
 		var err error
 		netid, err = vmap.netIDManager.AllocateNext()
 		if err != nil {
+			recordAllocationFailed()
 			return 0, err
 		}
+		recordVNIDAllocated()
 		allocated = true
 	default:
 		return 0, fmt.Errorf("invalid pod network action: %v", action)
@@ -194,6 +245,72 @@ func (vmap *masterVNIDMap) updateNetID(nsName string, action networkapihelpers.P
 	return netid, nil
 }
 
+// populate seeds the vnid map from existing NetNamespaces under the lock, so
+// startup cannot race with concurrently delivered watch events.
+func (vmap *masterVNIDMap) populate(netnsList []networkv1.NetNamespace) {
+	vmap.lock.Lock()
+	defer vmap.lock.Unlock()
+
+	for _, netns := range netnsList {
+		if err := vmap.markAllocatedNetID(netns.NetID); err != nil {
+			utilruntime.HandleError(err)
+		}
+		vmap.setVNID(netns.Name, netns.NetID)
+	}
+}
+
+// resync reconciles the in-memory vnid map against the given list of
+// NetNamespaces, repairing drift from missed watch events: stale entries are
+// released and missing or changed entries are adopted.
+func (vmap *masterVNIDMap) resync(networkClient networkclient.Interface, netnsList []networkv1.NetNamespace) error {
+	vmap.lock.Lock()
+	defer vmap.lock.Unlock()
+
+	errList := []error{}
+	desired := make(map[string]uint32, len(netnsList))
+	for _, netns := range netnsList {
+		desired[netns.NetName] = netns.NetID
+	}
+
+	for nsName := range vmap.ids {
+		if _, found := desired[nsName]; !found {
+			// The list was taken before we acquired the lock, so a namespace
+			// assigned in the meantime is missing from it. Only release the
+			// netid if the NetNamespace is really gone; releasing a live one
+			// would let the allocator hand the vnid to another namespace.
+			if _, err := networkClient.Network().NetNamespaces().Get(nsName, metav1.GetOptions{}); err == nil {
+				continue
+			} else if !kapierrors.IsNotFound(err) {
+				errList = append(errList, err)
+				continue
+			}
+			glog.Infof("Releasing netid for stale namespace %q during resync", nsName)
+			if err := vmap.releaseNetID(nsName); err != nil {
+				errList = append(errList, err)
+			}
+		}
+	}
+
+	for nsName, netid := range desired {
+		if id, found := vmap.getVNID(nsName); found {
+			if id == netid {
+				continue
+			}
+			// The netid changed behind our back; release the old one first
+			if err := vmap.releaseNetID(nsName); err != nil {
+				errList = append(errList, err)
+			}
+		}
+		glog.Infof("Adopting netid %d for namespace %q during resync", netid, nsName)
+		if err := vmap.markAllocatedNetID(netid); err != nil {
+			errList = append(errList, err)
+			continue
+		}
+		vmap.setVNID(nsName, netid)
+	}
+	return kerrors.NewAggregate(errList)
+}
+
 // assignVNID, revokeVNID and updateVNID methods updates in-memory structs and persists etcd objects
 func (vmap *masterVNIDMap) assignVNID(networkClient networkclient.Interface, nsName string) error {
 	vmap.lock.Lock()
@@ -223,18 +340,29 @@ func (vmap *masterVNIDMap) assignVNID(networkClient networkclient.Interface, nsN
 }
 
 func (vmap *masterVNIDMap) revokeVNID(networkClient networkclient.Interface, nsName string) error {
+	return vmap.revokeVNIDs(networkClient, []string{nsName})
+}
+
+// revokeVNIDs revokes multiple NetNamespaces under a single lock acquisition,
+// releasing the netids back to the allocator in bulk. This keeps namespace
+// deletion storms from repeatedly contending on the vnid lock.
+func (vmap *masterVNIDMap) revokeVNIDs(networkClient networkclient.Interface, nsNames []string) error {
 	vmap.lock.Lock()
 	defer vmap.lock.Unlock()
 
-	// Delete NetNamespace object
-	if err := networkClient.Network().NetNamespaces().Delete(nsName, &metav1.DeleteOptions{}); err != nil {
-		return err
-	}
+	errList := []error{}
+	for _, nsName := range nsNames {
+		// Delete NetNamespace object
+		if err := networkClient.Network().NetNamespaces().Delete(nsName, &metav1.DeleteOptions{}); err != nil {
+			errList = append(errList, err)
+			continue
+		}
 
-	if err := vmap.releaseNetID(nsName); err != nil {
-		return err
+		if err := vmap.releaseNetID(nsName); err != nil {
+			errList = append(errList, err)
+		}
 	}
-	return nil
+	return kerrors.NewAggregate(errList)
 }
 
 func (vmap *masterVNIDMap) updateVNID(networkClient networkclient.Interface, origNetns *networkv1.NetNamespace) error {
@@ -269,29 +397,101 @@ func (vmap *masterVNIDMap) updateVNID(networkClient networkclient.Interface, ori
 
 //--------------------- Master methods ----------------------
 
+const (
+	// revokeBatchInterval is how long we wait to coalesce further namespace
+	// deletions into a single bulk revoke.
+	revokeBatchInterval = 100 * time.Millisecond
+	// revokeBatchMax bounds how many deletions are coalesced into one revoke.
+	revokeBatchMax = 100
+
+	// vnidResyncInterval is how often the vnid map is reconciled against the
+	// listed NetNamespaces to repair drift from missed watch events.
+	vnidResyncInterval = 10 * time.Minute
+)
+
 func (master *OsdnMaster) startVNIDMaster() error {
 	if err := master.initNetIDAllocator(); err != nil {
 		return err
 	}
 
+	master.deletedNamespaces = make(chan string, revokeBatchMax)
+	master.wg.Add(1)
+	go func() {
+		defer master.wg.Done()
+		master.revokeVNIDsLoop()
+	}()
+	master.runUntilStop(master.resyncVNIDs, vnidResyncInterval)
+
 	master.watchNamespaces()
 	master.watchNetNamespaces()
 
 	return nil
 }
 
-func (master *OsdnMaster) initNetIDAllocator() error {
+// resyncVNIDs re-lists NetNamespaces and reconciles the vnid map against
+// them, so the master recovers from missed watch events.
+func (master *OsdnMaster) resyncVNIDs() {
 	netnsList, err := master.networkClient.Network().NetNamespaces().List(metav1.ListOptions{})
 	if err != nil {
-		return err
+		utilruntime.HandleError(fmt.Errorf("Error listing NetNamespaces for resync: %v", err))
+		return
 	}
+	if err := master.vnids.resync(master.networkClient, netnsList.Items); err != nil {
+		utilruntime.HandleError(fmt.Errorf("Error resyncing vnid map: %v", err))
+	}
+}
 
-	for _, netns := range netnsList.Items {
-		if err := master.vnids.markAllocatedNetID(netns.NetID); err != nil {
-			utilruntime.HandleError(err)
+// revokeVNIDsLoop drains namespace deletion events, coalescing rapid
+// deletions into bulk revokes so that deletion storms do not fall behind.
+// It returns when Stop is called.
+func (master *OsdnMaster) revokeVNIDsLoop() {
+	for {
+		var nsName string
+		select {
+		case <-master.stopCh:
+			return
+		case nsName = <-master.deletedNamespaces:
+		}
+
+		nsNames := []string{nsName}
+		timeout := time.After(revokeBatchInterval)
+	coalesce:
+		for len(nsNames) < revokeBatchMax {
+			select {
+			case name := <-master.deletedNamespaces:
+				nsNames = append(nsNames, name)
+			case <-timeout:
+				break coalesce
+			}
+		}
+
+		// Skip namespaces that were recreated while the deletion was being
+		// coalesced; revoking them now would delete the NetNamespace the
+		// recreate just assigned and release its netid out from under it.
+		revoke := nsNames[:0]
+		for _, name := range nsNames {
+			if _, err := master.namespaceInformer.Lister().Get(name); err == nil {
+				glog.V(2).Infof("Namespace %q was recreated before its netid was revoked; keeping it", name)
+				continue
+			}
+			revoke = append(revoke, name)
+		}
+		if len(revoke) == 0 {
+			continue
+		}
+		if err := master.vnids.revokeVNIDs(master.networkClient, revoke); err != nil {
+			utilruntime.HandleError(fmt.Errorf("Error revoking netids: %v", err))
 		}
-		master.vnids.setVNID(netns.Name, netns.NetID)
 	}
+}
+
+func (master *OsdnMaster) initNetIDAllocator() error {
+	netnsList, err := master.networkClient.Network().NetNamespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	master.vnids.populate(netnsList.Items)
 
 	return nil
 }
@@ -313,8 +513,11 @@ func (master *OsdnMaster) handleAddOrUpdateNamespace(obj, _ interface{}, eventTy
 func (master *OsdnMaster) handleDeleteNamespace(obj interface{}) {
 	ns := obj.(*kapi.Namespace)
 	glog.V(5).Infof("Watch %s event for Namespace %q", watch.Deleted, ns.Name)
-	if err := master.vnids.revokeVNID(master.networkClient, ns.Name); err != nil {
-		utilruntime.HandleError(fmt.Errorf("Error revoking netid: %v", err))
+	// Do not block the informer's dispatch goroutine forever if Stop has
+	// already terminated the revoke loop
+	select {
+	case master.deletedNamespaces <- ns.Name:
+	case <-master.stopCh:
 	}
 }
 
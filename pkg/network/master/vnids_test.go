@@ -5,6 +5,8 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	networkv1 "github.com/openshift/api/network/v1"
+	networkfake "github.com/openshift/client-go/network/clientset/versioned/fake"
 	"github.com/openshift/library-go/pkg/network/networkapihelpers"
 	"github.com/openshift/origin/pkg/network"
 )
@@ -68,6 +70,111 @@ func TestMasterVNIDMap(t *testing.T) {
 	checkCurrentVNIDs(t, vmap, 0, 0)
 }
 
+func TestMasterVNIDMapRange(t *testing.T) {
+	// Invalid ranges are rejected
+	if _, err := newMasterVNIDMapWithRange(true, network.MinVNID-1, network.MaxVNID); err == nil {
+		t.Fatalf("Expected error for too-small minVNID")
+	}
+	if _, err := newMasterVNIDMapWithRange(true, network.MinVNID, network.MaxVNID+1); err == nil {
+		t.Fatalf("Expected error for too-large maxVNID")
+	}
+	if _, err := newMasterVNIDMapWithRange(true, 200, 100); err == nil {
+		t.Fatalf("Expected error for inverted range")
+	}
+
+	// A custom range restricts the allocator
+	vmap, err := newMasterVNIDMapWithRange(true, 100, 109)
+	checkNoErr(t, err)
+	if free := vmap.netIDManager.Free(); free != 10 {
+		t.Fatalf("Wrong number of free netids: %d vs 10", free)
+	}
+	id, _, err := vmap.allocateNetID("alpha")
+	checkNoErr(t, err)
+	if id < 100 || id > 109 {
+		t.Fatalf("Allocated netid %d outside configured range", id)
+	}
+
+	// SetVNIDRange wires the range into the map created at Start
+	if err := SetVNIDRange(network.MinVNID-1, network.MaxVNID); err == nil {
+		t.Fatalf("Expected error for invalid range")
+	}
+	checkNoErr(t, SetVNIDRange(100, 109))
+	defer SetVNIDRange(network.MinVNID, network.MaxVNID)
+	vmap = newMasterVNIDMap(true)
+	if free := vmap.netIDManager.Free(); free != 10 {
+		t.Fatalf("Wrong number of free netids: %d vs 10", free)
+	}
+}
+
+func TestMasterVNIDMapBulkRevoke(t *testing.T) {
+	vmap := newMasterVNIDMap(true)
+	networkClient := networkfake.NewSimpleClientset()
+
+	nsNames := []string{"alpha", "bravo", "charlie"}
+	for _, nsName := range nsNames {
+		checkNoErr(t, vmap.assignVNID(networkClient, nsName))
+	}
+	checkCurrentVNIDs(t, vmap, 3, 3)
+
+	checkNoErr(t, vmap.revokeVNIDs(networkClient, nsNames))
+	checkCurrentVNIDs(t, vmap, 0, 0)
+
+	netnsList, err := networkClient.Network().NetNamespaces().List(metav1.ListOptions{})
+	checkNoErr(t, err)
+	if len(netnsList.Items) != 0 {
+		t.Fatalf("Expected all NetNamespaces to be deleted, found %d", len(netnsList.Items))
+	}
+
+	// Revoking missing namespaces must surface errors but not panic
+	checkErr(t, vmap.revokeVNIDs(networkClient, []string{"bogus"}))
+}
+
+func TestMasterVNIDMapResync(t *testing.T) {
+	vmap := newMasterVNIDMap(true)
+	networkClient := networkfake.NewSimpleClientset()
+
+	aID, _, err := vmap.allocateNetID("alpha")
+	checkNoErr(t, err)
+	_, _, err = vmap.allocateNetID("stale")
+	checkNoErr(t, err)
+	rID, _, err := vmap.allocateNetID("racer")
+	checkNoErr(t, err)
+	checkCurrentVNIDs(t, vmap, 3, 3)
+
+	// "racer" was assigned after the list was taken; its NetNamespace exists
+	// even though the list below does not mention it, so it must survive
+	_, err = networkClient.Network().NetNamespaces().Create(&networkv1.NetNamespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "racer"},
+		NetName:    "racer",
+		NetID:      rID,
+	})
+	checkNoErr(t, err)
+
+	// The listed state is missing "stale", changes "alpha" and adds "adopted"
+	netnsList := []networkv1.NetNamespace{
+		{NetName: "alpha", NetID: aID + 100},
+		{NetName: "adopted", NetID: aID + 200},
+	}
+	checkNoErr(t, vmap.resync(networkClient, netnsList))
+
+	checkCurrentVNIDs(t, vmap, 3, 3)
+	if _, found := vmap.getVNID("stale"); found {
+		t.Fatalf("Expected stale namespace to be released")
+	}
+	if id, found := vmap.getVNID("racer"); !found || id != rID {
+		t.Fatalf("Expected concurrently assigned namespace to survive resync, got %d, %v", id, found)
+	}
+	if id, found := vmap.getVNID("alpha"); !found || id != aID+100 {
+		t.Fatalf("Expected changed netid to be adopted, got %d, %v", id, found)
+	}
+	if id, found := vmap.getVNID("adopted"); !found || id != aID+200 {
+		t.Fatalf("Expected new netid to be adopted, got %d, %v", id, found)
+	}
+	if vmap.netIDManager.Has(aID) {
+		t.Fatalf("Expected old netid %d to be released", aID)
+	}
+}
+
 func checkNoErr(t *testing.T, err error) {
 	if err != nil {
 		t.Fatal(err)
@@ -13,6 +13,7 @@ import (
 	"github.com/golang/glog"
 
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilwait "k8s.io/apimachinery/pkg/util/wait"
 
 	networkinformers "github.com/openshift/client-go/network/informers/externalversions"
 	"github.com/openshift/origin/pkg/network/common"
@@ -20,6 +21,9 @@ import (
 )
 
 type egressIPWatcher struct {
+	// Guards iptablesMark and draining. The tracker callbacks below run on
+	// the tracker's goroutine while ReconcileEgressIPs and DrainEgressIPs
+	// run on their own, so every access to that state must hold this lock.
 	sync.Mutex
 
 	tracker *common.EgressIPTracker
@@ -36,15 +40,49 @@ type egressIPWatcher struct {
 	localEgressLink netlink.Link
 	localEgressNet  *net.IPNet
 
+	// If set, egress IPs are hosted on this interface rather than on the
+	// interface owning localIP
+	egressInterface string
+
+	// How often assigned addresses and iptables rules are re-asserted
+	// against the actual local state; 0 disables reconciliation
+	reconcileInterval time.Duration
+
+	// Optional; nil disables metrics
+	metrics EgressIPMetrics
+
+	// Set by DrainEgressIPs; while true, new local egress IP claims are refused
+	draining bool
+
 	testModeChan chan string
 }
 
+// defaultEgressReconcileInterval is how often the local netlink/iptables state
+// is reconciled against the assigned egress IPs.
+const defaultEgressReconcileInterval = 1 * time.Minute
+
+// EgressIPMetrics receives callbacks about egress IP assignment on the local
+// node. Implementations must be safe for concurrent use. The interface
+// avoids a direct dependency on any one metrics library.
+type EgressIPMetrics interface {
+	// EgressIPAssigned is called after an egress IP is successfully assigned
+	// locally; count is the number of egress IPs the node now hosts.
+	EgressIPAssigned(egressIP string, duration time.Duration, count int)
+	// EgressIPReleased is called after an egress IP is successfully released;
+	// count is the number of egress IPs the node still hosts.
+	EgressIPReleased(egressIP string, duration time.Duration, count int)
+	// EgressIPError is called when assigning or releasing an egress IP fails.
+	EgressIPError(egressIP string)
+}
+
 func newEgressIPWatcher(oc *ovsController, localIP string, masqueradeBit *int32) *egressIPWatcher {
 	eip := &egressIPWatcher{
 		oc:      oc,
 		localIP: localIP,
 
 		iptablesMark: make(map[string]string),
+
+		reconcileInterval: defaultEgressReconcileInterval,
 	}
 	if masqueradeBit != nil {
 		eip.masqueradeBit = 1 << uint32(*masqueradeBit)
@@ -54,10 +92,82 @@ func newEgressIPWatcher(oc *ovsController, localIP string, masqueradeBit *int32)
 	return eip
 }
 
-func (eip *egressIPWatcher) Start(networkInformers networkinformers.SharedInformerFactory, iptables *NodeIPTables) error {
+// SetMetrics registers a metrics sink notified of local egress IP assignment
+// activity. It must be called before Start.
+func (eip *egressIPWatcher) SetMetrics(metrics EgressIPMetrics) {
+	eip.metrics = metrics
+}
+
+// SetEgressInterface configures egress IPs to be hosted on the named
+// interface instead of the interface owning the node IP, for multi-NIC hosts
+// where egress traffic should leave a dedicated link. It must be called
+// before Start.
+func (eip *egressIPWatcher) SetEgressInterface(name string) {
+	eip.egressInterface = name
+}
+
+// Overridable for testing
+var linkDetailsByName = getLinkDetailsByName
+
+// Overridable for testing
+var netlinkAddrAdd = netlink.AddrAdd
+
+// addEgressIPBackoff bounds retries when adding an egress IP address fails
+// with a transient netlink error (eg during an interface flap).
+// Overridable for testing.
+var addEgressIPBackoff = utilwait.Backoff{
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Steps:    4,
+}
+
+// isTransientNetlinkError reports whether err is a netlink failure that is
+// worth retrying rather than giving up on the assignment immediately.
+func isTransientNetlinkError(err error) bool {
+	switch err {
+	case syscall.EADDRNOTAVAIL, syscall.EAGAIN, syscall.EBUSY, syscall.ENOBUFS:
+		return true
+	}
+	return false
+}
+
+func getLinkDetailsByName(name string) (netlink.Link, *net.IPNet, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not find egress interface %q: %v", name, err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get addresses of egress interface %q: %v", name, err)
+	}
+	if len(addrs) == 0 {
+		return nil, nil, fmt.Errorf("egress interface %q has no IPv4 address", name)
+	}
+	_, ipNet, err := net.ParseCIDR(addrs[0].IPNet.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse CIDR network from egress interface %q: %v", name, err)
+	}
+	return link, ipNet, nil
+}
+
+func (eip *egressIPWatcher) resolveEgressLink() error {
 	var err error
-	if eip.localEgressLink, eip.localEgressNet, err = GetLinkDetails(eip.localIP); err != nil {
-		// Not expected, should already be caught by node.New()
+	if eip.egressInterface != "" {
+		// An explicitly configured interface must exist and have an address
+		eip.localEgressLink, eip.localEgressNet, err = linkDetailsByName(eip.egressInterface)
+		return err
+	}
+	// A lookup failure here is not fatal; it is not expected and should
+	// already have been caught by node.New()
+	eip.localEgressLink, eip.localEgressNet, _ = GetLinkDetails(eip.localIP)
+	return nil
+}
+
+func (eip *egressIPWatcher) Start(networkInformers networkinformers.SharedInformerFactory, iptables *NodeIPTables) error {
+	if err := eip.resolveEgressLink(); err != nil {
+		return err
+	}
+	if eip.localEgressLink == nil {
 		return nil
 	}
 
@@ -67,10 +177,29 @@ func (eip *egressIPWatcher) Start(networkInformers networkinformers.SharedInform
 	eip.vxlanMonitor = newEgressVXLANMonitor(eip.oc.ovs, eip.tracker, updates)
 	go eip.watchVXLAN(updates)
 
+	if eip.reconcileInterval > 0 {
+		go utilwait.Forever(eip.ReconcileEgressIPs, eip.reconcileInterval)
+	}
+
 	eip.tracker.Start(networkInformers.Network().V1().HostSubnets(), networkInformers.Network().V1().NetNamespaces())
 	return nil
 }
 
+// ReconcileEgressIPs re-asserts the local address and iptables rule for every
+// egress IP this node currently hosts, so that state removed behind our back
+// (eg by an admin flushing addresses, or a reboot) is repaired without
+// waiting for another watch event.
+func (eip *egressIPWatcher) ReconcileEgressIPs() {
+	eip.Lock()
+	defer eip.Unlock()
+
+	for egressIP, mark := range eip.iptablesMark {
+		if err := eip.assignEgressIP(egressIP, mark); err != nil {
+			utilruntime.HandleError(fmt.Errorf("Error reconciling Egress IP %q: %v", egressIP, err))
+		}
+	}
+}
+
 // Convert vnid to a hex value that is not 0, does not have masqueradeBit set, and isn't
 // the same value as would be returned for any other valid vnid.
 func getMarkForVNID(vnid, masqueradeBit uint32) string {
@@ -83,12 +212,67 @@ func getMarkForVNID(vnid, masqueradeBit uint32) string {
 	return fmt.Sprintf("0x%08x", vnid)
 }
 
+// validateLocalEgressIP checks up front that an egress IP assigned to the
+// local node can actually be hosted here, so misconfigurations are reported
+// as soon as the IP is claimed rather than only when the address add fails.
+func (eip *egressIPWatcher) validateLocalEgressIP(egressIP string) error {
+	if egressIP == eip.localIP {
+		return fmt.Errorf("desired egress IP %q is the node IP", egressIP)
+	}
+	if eip.localEgressNet == nil {
+		return nil
+	}
+	parsed := net.ParseIP(egressIP)
+	if parsed == nil {
+		return fmt.Errorf("could not parse egress IP %q", egressIP)
+	}
+	if !eip.localEgressNet.Contains(parsed) {
+		return fmt.Errorf("egress IP %q is not in local network %s", egressIP, eip.localEgressNet.String())
+	}
+	return nil
+}
+
+// DrainEgressIPs releases every egress IP currently hosted on the local node
+// and stops accepting new ones, for use before taking the node down for
+// maintenance. The HostSubnet and NetNamespace objects are left intact, so
+// if automatic egress IP assignment is in use the master can re-home the
+// addresses to other nodes.
+func (eip *egressIPWatcher) DrainEgressIPs() {
+	eip.Lock()
+	defer eip.Unlock()
+
+	eip.draining = true
+	for egressIP, mark := range eip.iptablesMark {
+		delete(eip.iptablesMark, egressIP)
+		if err := eip.releaseEgressIP(egressIP, mark); err != nil {
+			utilruntime.HandleError(fmt.Errorf("Error releasing Egress IP %q: %v", egressIP, err))
+		}
+	}
+}
+
 func (eip *egressIPWatcher) ClaimEgressIP(vnid uint32, egressIP, nodeIP string) {
+	eip.Lock()
+	defer eip.Unlock()
+
 	if nodeIP == eip.localIP {
+		if eip.draining {
+			utilruntime.HandleError(fmt.Errorf("Cannot claim Egress IP %q on this node: egress IPs are being drained", egressIP))
+			return
+		}
+		if err := eip.validateLocalEgressIP(egressIP); err != nil {
+			utilruntime.HandleError(fmt.Errorf("Cannot claim Egress IP %q on this node: %v", egressIP, err))
+			return
+		}
 		mark := getMarkForVNID(vnid, eip.masqueradeBit)
 		eip.iptablesMark[egressIP] = mark
+		start := time.Now()
 		if err := eip.assignEgressIP(egressIP, mark); err != nil {
 			utilruntime.HandleError(fmt.Errorf("Error assigning Egress IP %q: %v", egressIP, err))
+			if eip.metrics != nil {
+				eip.metrics.EgressIPError(egressIP)
+			}
+		} else if eip.metrics != nil {
+			eip.metrics.EgressIPAssigned(egressIP, time.Since(start), len(eip.iptablesMark))
 		}
 	} else if eip.vxlanMonitor != nil {
 		eip.vxlanMonitor.AddNode(nodeIP)
@@ -96,11 +280,20 @@ func (eip *egressIPWatcher) ClaimEgressIP(vnid uint32, egressIP, nodeIP string)
 }
 
 func (eip *egressIPWatcher) ReleaseEgressIP(egressIP, nodeIP string) {
+	eip.Lock()
+	defer eip.Unlock()
+
 	if nodeIP == eip.localIP {
 		mark := eip.iptablesMark[egressIP]
 		delete(eip.iptablesMark, egressIP)
+		start := time.Now()
 		if err := eip.releaseEgressIP(egressIP, mark); err != nil {
 			utilruntime.HandleError(fmt.Errorf("Error releasing Egress IP %q: %v", egressIP, err))
+			if eip.metrics != nil {
+				eip.metrics.EgressIPError(egressIP)
+			}
+		} else if eip.metrics != nil {
+			eip.metrics.EgressIPReleased(egressIP, time.Since(start), len(eip.iptablesMark))
 		}
 	} else if eip.vxlanMonitor != nil {
 		eip.vxlanMonitor.RemoveNode(nodeIP)
@@ -123,7 +316,9 @@ func (eip *egressIPWatcher) SetNamespaceEgressDropped(vnid uint32) {
 }
 
 func (eip *egressIPWatcher) SetNamespaceEgressViaEgressIP(vnid uint32, egressIP, nodeIP string) {
+	eip.Lock()
 	mark := eip.iptablesMark[egressIP]
+	eip.Unlock()
 	if err := eip.oc.SetNamespaceEgressViaEgressIP(vnid, nodeIP, mark); err != nil {
 		utilruntime.HandleError(fmt.Errorf("Error updating Namespace egress rules for VNID %d: %v", vnid, err))
 	}
@@ -148,25 +343,46 @@ func (eip *egressIPWatcher) assignEgressIP(egressIP, mark string) error {
 	if !eip.localEgressNet.Contains(addr.IP) {
 		return fmt.Errorf("egress IP %q is not in local network %s of interface %s", egressIP, eip.localEgressNet.String(), eip.localEgressLink.Attrs().Name)
 	}
-	err = netlink.AddrAdd(eip.localEgressLink, addr)
-	if err != nil {
-		if err == syscall.EEXIST {
+	var addrAdded bool
+	var lastErr error
+	err = utilwait.ExponentialBackoff(addEgressIPBackoff, func() (bool, error) {
+		lastErr = netlinkAddrAdd(eip.localEgressLink, addr)
+		if lastErr == nil {
+			addrAdded = true
+			return true, nil
+		}
+		if lastErr == syscall.EEXIST {
 			glog.V(2).Infof("Egress IP %q already exists on %s", egressIPNet, eip.localEgressLink.Attrs().Name)
-		} else {
-			return fmt.Errorf("could not add egress IP %q to %s: %v", egressIPNet, eip.localEgressLink.Attrs().Name, err)
+			lastErr = nil
+			return true, nil
 		}
-	}
-	// Use arping to try to update other hosts ARP caches, in case this IP was
-	// previously active on another node. (Based on code from "ifup".)
-	go func() {
-		out, err := exec.Command("/sbin/arping", "-q", "-A", "-c", "1", "-I", eip.localEgressLink.Attrs().Name, egressIP).CombinedOutput()
-		if err != nil {
-			glog.Warningf("Failed to send ARP claim for egress IP %q: %v (%s)", egressIP, err, string(out))
-			return
+		if isTransientNetlinkError(lastErr) {
+			glog.V(2).Infof("Transient error adding egress IP %q to %s (will retry): %v", egressIPNet, eip.localEgressLink.Attrs().Name, lastErr)
+			return false, nil
 		}
-		time.Sleep(2 * time.Second)
-		_ = exec.Command("/sbin/arping", "-q", "-U", "-c", "1", "-I", eip.localEgressLink.Attrs().Name, egressIP).Run()
-	}()
+		return false, lastErr
+	})
+	if err == utilwait.ErrWaitTimeout {
+		err = lastErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not add egress IP %q to %s: %v", egressIPNet, eip.localEgressLink.Attrs().Name, err)
+	}
+	if addrAdded {
+		// Use arping to try to update other hosts ARP caches, in case this IP was
+		// previously active on another node. (Based on code from "ifup".) Only done
+		// when the address was actually added, so periodic reconciles don't keep
+		// re-announcing it.
+		go func() {
+			out, err := exec.Command("/sbin/arping", "-q", "-A", "-c", "1", "-I", eip.localEgressLink.Attrs().Name, egressIP).CombinedOutput()
+			if err != nil {
+				glog.Warningf("Failed to send ARP claim for egress IP %q: %v (%s)", egressIP, err, string(out))
+				return
+			}
+			time.Sleep(2 * time.Second)
+			_ = exec.Command("/sbin/arping", "-q", "-U", "-c", "1", "-I", eip.localEgressLink.Attrs().Name, egressIP).Run()
+		}()
+	}
 
 	if err := eip.iptables.AddEgressIPRules(egressIP, mark); err != nil {
 		return fmt.Errorf("could not add egress IP iptables rule: %v", err)
@@ -4,15 +4,22 @@ package node
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
+	utilwait "k8s.io/apimachinery/pkg/util/wait"
+	iptablestesting "k8s.io/kubernetes/pkg/util/iptables/testing"
 
 	networkapi "github.com/openshift/api/network/v1"
+	"github.com/vishvananda/netlink"
 )
 
 // Checks the "testModeChan" of eip and ensures that the expected netlink event(s)
@@ -521,6 +528,255 @@ func TestNodeIPAsEgressIP(t *testing.T) {
 	}
 }
 
+func TestEgressIPOutsideLocalSubnet(t *testing.T) {
+	eip, _ := setupEgressIPWatcher(t)
+	_, localNet, err := net.ParseCIDR("172.17.0.0/24")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eip.localEgressNet = localNet
+
+	// An egress IP outside the local interface's subnet is rejected up front.
+	// (It will log an error but this test doesn't notice that.)
+	updateNamespaceEgress(eip, 42, []string{"192.168.1.100"})
+	updateNodeEgress(eip, "172.17.0.4", []string{"192.168.1.100"})
+	err = assertNoNetlinkChanges(eip)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// An egress IP inside the subnet is still claimed normally
+	updateNamespaceEgress(eip, 43, []string{"172.17.0.100"})
+	updateNodeEgress(eip, "172.17.0.4", []string{"192.168.1.100", "172.17.0.100"})
+	err = assertNetlinkChange(eip, "claim 172.17.0.100")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+type testEgressIPMetrics struct {
+	assigned []string
+	released []string
+	errors   []string
+	count    int
+}
+
+func (m *testEgressIPMetrics) EgressIPAssigned(egressIP string, duration time.Duration, count int) {
+	m.assigned = append(m.assigned, egressIP)
+	m.count = count
+}
+
+func (m *testEgressIPMetrics) EgressIPReleased(egressIP string, duration time.Duration, count int) {
+	m.released = append(m.released, egressIP)
+	m.count = count
+}
+
+func (m *testEgressIPMetrics) EgressIPError(egressIP string) {
+	m.errors = append(m.errors, egressIP)
+}
+
+func TestEgressIPMetrics(t *testing.T) {
+	eip, _ := setupEgressIPWatcher(t)
+	metrics := &testEgressIPMetrics{}
+	eip.SetMetrics(metrics)
+
+	updateNamespaceEgress(eip, 42, []string{"172.17.0.100"})
+	updateNodeEgress(eip, "172.17.0.4", []string{"172.17.0.100", "172.17.0.101"})
+	updateNamespaceEgress(eip, 43, []string{"172.17.0.101"})
+	err := assertNetlinkChange(eip, "claim 172.17.0.100", "claim 172.17.0.101")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(metrics.assigned) != 2 || metrics.count != 2 {
+		t.Fatalf("unexpected assignment metrics: %#v", metrics)
+	}
+
+	updateNodeEgress(eip, "172.17.0.4", []string{"172.17.0.100"})
+	err = assertNetlinkChange(eip, "release 172.17.0.101")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(metrics.released) != 1 || metrics.released[0] != "172.17.0.101" || metrics.count != 1 {
+		t.Fatalf("unexpected release metrics: %#v", metrics)
+	}
+	if len(metrics.errors) != 0 {
+		t.Fatalf("unexpected error metrics: %#v", metrics)
+	}
+}
+
+func TestEgressInterfaceSelection(t *testing.T) {
+	origLinkDetailsByName := linkDetailsByName
+	defer func() { linkDetailsByName = origLinkDetailsByName }()
+
+	fakeLink := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth9"}}
+	_, fakeNet, err := net.ParseCIDR("192.168.9.0/24")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	linkDetailsByName = func(name string) (netlink.Link, *net.IPNet, error) {
+		if name != "eth9" {
+			return nil, nil, fmt.Errorf("could not find egress interface %q", name)
+		}
+		return fakeLink, fakeNet, nil
+	}
+
+	// Explicit interface overrides the localIP-derived link
+	eip, _ := setupEgressIPWatcher(t)
+	eip.SetEgressInterface("eth9")
+	if err := eip.resolveEgressLink(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if eip.localEgressLink != netlink.Link(fakeLink) || eip.localEgressNet.String() != "192.168.9.0/24" {
+		t.Fatalf("egress link not resolved from configured interface: %v, %v", eip.localEgressLink, eip.localEgressNet)
+	}
+
+	// A nonexistent interface is a hard error
+	eip, _ = setupEgressIPWatcher(t)
+	eip.SetEgressInterface("eth10")
+	if err := eip.resolveEgressLink(); err == nil {
+		t.Fatalf("expected error for nonexistent egress interface")
+	}
+}
+
+func TestAssignEgressIPTransientRetry(t *testing.T) {
+	origAddrAdd := netlinkAddrAdd
+	origBackoff := addEgressIPBackoff
+	defer func() {
+		netlinkAddrAdd = origAddrAdd
+		addEgressIPBackoff = origBackoff
+	}()
+	addEgressIPBackoff = utilwait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+
+	eip, _ := setupEgressIPWatcher(t)
+	eip.testModeChan = nil
+	eip.localEgressLink = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth9"}}
+	_, localNet, err := net.ParseCIDR("172.17.0.0/24")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eip.localEgressNet = localNet
+	eip.iptables = &NodeIPTables{
+		ipt:                iptablestesting.NewFake(),
+		clusterNetworkCIDR: []string{"10.128.0.0/14"},
+		egressIPs:          map[string]string{},
+	}
+
+	// A transient failure is retried until the address add succeeds. (EEXIST
+	// on the final attempt so we don't try to arping in a unit test.)
+	attempts := 0
+	netlinkAddrAdd = func(link netlink.Link, addr *netlink.Addr) error {
+		attempts++
+		if attempts < 3 {
+			return syscall.EADDRNOTAVAIL
+		}
+		return syscall.EEXIST
+	}
+	if err := eip.assignEgressIP("172.17.0.100", "0x2a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 AddrAdd attempts, got %d", attempts)
+	}
+	if eip.iptables.egressIPs["172.17.0.100"] != "0x2a" {
+		t.Fatalf("iptables rule not added after successful retry")
+	}
+
+	// A non-transient failure is not retried, and the iptables rule is not added
+	attempts = 0
+	netlinkAddrAdd = func(link netlink.Link, addr *netlink.Addr) error {
+		attempts++
+		return syscall.EPERM
+	}
+	if err := eip.assignEgressIP("172.17.0.101", "0x2b"); err == nil {
+		t.Fatalf("expected error assigning egress IP")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected 1 AddrAdd attempt, got %d", attempts)
+	}
+	if _, ok := eip.iptables.egressIPs["172.17.0.101"]; ok {
+		t.Fatalf("iptables rule added despite failed address add")
+	}
+
+	// A transient failure that never resolves eventually gives up
+	attempts = 0
+	netlinkAddrAdd = func(link netlink.Link, addr *netlink.Addr) error {
+		attempts++
+		return syscall.EADDRNOTAVAIL
+	}
+	if err := eip.assignEgressIP("172.17.0.102", "0x2c"); err == nil {
+		t.Fatalf("expected error assigning egress IP")
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 AddrAdd attempts, got %d", attempts)
+	}
+}
+
+func TestReconcileEgressIPs(t *testing.T) {
+	eip, _ := setupEgressIPWatcher(t)
+
+	updateNamespaceEgress(eip, 42, []string{"172.17.0.100"})
+	updateNodeEgress(eip, "172.17.0.4", []string{"172.17.0.100", "172.17.0.101"})
+	updateNamespaceEgress(eip, 43, []string{"172.17.0.101"})
+	err := assertNetlinkChange(eip, "claim 172.17.0.100", "claim 172.17.0.101")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Reconciliation re-asserts every locally-hosted egress IP
+	eip.ReconcileEgressIPs()
+	err = assertNetlinkChange(eip, "claim 172.17.0.100", "claim 172.17.0.101")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Released IPs are no longer reconciled
+	updateNodeEgress(eip, "172.17.0.4", []string{"172.17.0.100"})
+	err = assertNetlinkChange(eip, "release 172.17.0.101")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eip.ReconcileEgressIPs()
+	err = assertNetlinkChange(eip, "claim 172.17.0.100")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = assertNoNetlinkChanges(eip)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestDrainEgressIPs(t *testing.T) {
+	eip, _ := setupEgressIPWatcher(t)
+
+	updateNamespaceEgress(eip, 42, []string{"172.17.0.100"})
+	updateNodeEgress(eip, "172.17.0.4", []string{"172.17.0.100", "172.17.0.101"})
+	updateNamespaceEgress(eip, 43, []string{"172.17.0.101"})
+	err := assertNetlinkChange(eip, "claim 172.17.0.100", "claim 172.17.0.101")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Draining releases every locally-hosted egress IP
+	eip.DrainEgressIPs()
+	err = assertNetlinkChange(eip, "release 172.17.0.100", "release 172.17.0.101")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(eip.iptablesMark) != 0 {
+		t.Fatalf("expected no remaining local egress IPs, got %#v", eip.iptablesMark)
+	}
+
+	// While draining, new claims for the local node are refused. (It will log
+	// an error but this test doesn't notice that.)
+	updateNamespaceEgress(eip, 44, []string{"172.17.0.102"})
+	updateNodeEgress(eip, "172.17.0.4", []string{"172.17.0.100", "172.17.0.101", "172.17.0.102"})
+	err = assertNoNetlinkChanges(eip)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
 func TestDuplicateNodeEgressIPs(t *testing.T) {
 	eip, flows := setupEgressIPWatcher(t)
 
@@ -723,6 +979,43 @@ func TestMarkForVNID(t *testing.T) {
 	}
 }
 
+// getMarkForVNID must map every valid VNID to a distinct mark; otherwise two
+// namespaces could end up sharing an egress IP's SNAT rule. The remapping of
+// VNIDs that have the masquerade bit set always sets bit 24, which a plain
+// 24-bit VNID can never have, so the mapping is injective. Verify that
+// exhaustively by round-tripping each mark back to its VNID: a mapping with a
+// working inverse cannot have collisions.
+func TestMarkForVNIDUniqueness(t *testing.T) {
+	// The kubelet default (--iptables-masquerade-bit=14)
+	masqueradeBit := uint32(1 << 14)
+
+	unmark := func(mark uint32) uint32 {
+		if mark == 0xff000000 {
+			return 0
+		}
+		if (mark & 0x01000000) != 0 {
+			return (mark ^ masqueradeBit) &^ 0x01000000
+		}
+		return mark
+	}
+
+	// VNIDs are 24 bits
+	for vnid := uint32(0); vnid < (1 << 24); vnid++ {
+		markStr := getMarkForVNID(vnid, masqueradeBit)
+		mark64, err := strconv.ParseUint(markStr, 0, 32)
+		if err != nil {
+			t.Fatalf("could not parse mark %q for VNID %d: %v", markStr, vnid, err)
+		}
+		mark := uint32(mark64)
+		if mark == 0 || (mark&masqueradeBit) != 0 {
+			t.Fatalf("mark %q for VNID %d is 0 or has the masquerade bit set", markStr, vnid)
+		}
+		if unmark(mark) != vnid {
+			t.Fatalf("mark %q for VNID %d does not round-trip", markStr, vnid)
+		}
+	}
+}
+
 func TestEgressNodeRenumbering(t *testing.T) {
 	eip, flows := setupEgressIPWatcher(t)
 
@@ -88,6 +88,45 @@ type OsdnNodeConfig struct {
 	IPTablesSyncPeriod time.Duration
 	ProxyMode          kubeproxyconfig.ProxyMode
 	MasqueradeBit      *int32
+
+	// SubnetLengthMismatchFatal causes node startup to fail if the node's
+	// allocated subnet length disagrees with the cluster network
+	// configuration, instead of just logging a warning.
+	SubnetLengthMismatchFatal bool
+
+	// SubnetWaitTimeout bounds how long node startup waits for the master to
+	// allocate this node's HostSubnet. Zero means the default of two minutes.
+	SubnetWaitTimeout time.Duration
+
+	// StartStatusFunc, if set, is called as each phase of Start completes,
+	// so orchestration can track progress beyond the binary started/failed
+	// signal. Nil disables reporting.
+	StartStatusFunc func(NodeStartStatus)
+}
+
+// NodeStartPhase identifies a stage of node startup that has completed.
+type NodeStartPhase string
+
+const (
+	// NodeStartPhaseSubnetObtained means the node's HostSubnet allocation
+	// was found and validated.
+	NodeStartPhaseSubnetObtained NodeStartPhase = "SubnetObtained"
+	// NodeStartPhaseFlowsInstalled means the OVS bridge and base flows are
+	// set up.
+	NodeStartPhaseFlowsInstalled NodeStartPhase = "FlowsInstalled"
+	// NodeStartPhaseWatchesStarted means the subnet, policy and service
+	// watches are registered.
+	NodeStartPhaseWatchesStarted NodeStartPhase = "WatchesStarted"
+	// NodeStartPhaseReady means startup is complete and the CNI config is
+	// about to be written to signal readiness to the kubelet.
+	NodeStartPhaseReady NodeStartPhase = "Ready"
+)
+
+// NodeStartStatus reports the completion of a startup phase, along with any
+// non-fatal warnings hit during it that are otherwise only logged.
+type NodeStartStatus struct {
+	Phase    NodeStartPhase
+	Warnings []string
 }
 
 type OsdnNode struct {
@@ -106,6 +145,10 @@ type OsdnNode struct {
 	mtu                uint32
 	cniDirPath         string
 
+	subnetLengthMismatchFatal bool
+	subnetWaitTimeout         time.Duration
+	startStatus               func(NodeStartStatus)
+
 	// Synchronizes operations on egressPolicies
 	egressPoliciesLock sync.Mutex
 	egressPolicies     map[uint32][]networkapi.EgressNetworkPolicy
@@ -183,6 +226,10 @@ func New(c *OsdnNodeConfig) (*OsdnNode, error) {
 		egressIP:           newEgressIPWatcher(oc, c.SelfIP, c.MasqueradeBit),
 		cniDirPath:         c.CNIConfDir,
 
+		subnetLengthMismatchFatal: c.SubnetLengthMismatchFatal,
+		subnetWaitTimeout:         c.SubnetWaitTimeout,
+		startStatus:               c.StartStatusFunc,
+
 		runtimeEndpoint: c.RuntimeEndpoint,
 		// 2 minutes is the current default value used in kubelet
 		runtimeRequestTimeout: 2 * time.Minute,
@@ -195,6 +242,15 @@ func New(c *OsdnNodeConfig) (*OsdnNode, error) {
 	return plugin, nil
 }
 
+// reportStartPhase tells the configured status callback that a startup phase
+// has completed, if a callback is set.
+func (node *OsdnNode) reportStartPhase(phase NodeStartPhase, warnings ...string) {
+	if node.startStatus == nil {
+		return
+	}
+	node.startStatus(NodeStartStatus{Phase: phase, Warnings: warnings})
+}
+
 // Set node IP if required
 func (c *OsdnNodeConfig) setNodeIP() error {
 	if len(c.Hostname) == 0 {
@@ -279,15 +335,18 @@ func (node *OsdnNode) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to get host network information: %v", err)
 	}
+	var startWarnings []string
 	if err := node.networkInfo.CheckHostNetworks(hostIPNets); err != nil {
 		// checkHostNetworks() errors *should* be fatal, but we didn't used to check this, and we can't break (mostly-)working nodes on upgrade.
 		utilruntime.HandleError(fmt.Errorf("Local networks conflict with SDN; this will eventually cause problems: %v", err))
+		startWarnings = append(startWarnings, fmt.Sprintf("local networks conflict with SDN: %v", err))
 	}
 
 	node.localSubnetCIDR, err = node.getLocalSubnet()
 	if err != nil {
 		return err
 	}
+	node.reportStartPhase(NodeStartPhaseSubnetObtained, startWarnings...)
 
 	var cidrList []string
 	for _, cn := range node.networkInfo.ClusterNetworks {
@@ -303,6 +362,7 @@ func (node *OsdnNode) Start() error {
 	if err != nil {
 		return fmt.Errorf("node SDN setup failed: %v", err)
 	}
+	node.reportStartPhase(NodeStartPhaseFlowsInstalled)
 
 	hsw := newHostSubnetWatcher(node.oc, node.localIP, node.networkInfo)
 	hsw.Start(node.networkInformers)
@@ -321,6 +381,7 @@ func (node *OsdnNode) Start() error {
 	if !node.useConnTrack {
 		node.watchServices()
 	}
+	node.reportStartPhase(NodeStartPhaseWatchesStarted)
 
 	glog.V(2).Infof("Starting openshift-sdn pod manager")
 	if err := node.podManager.Start(cniserver.CNIServerRunDir, node.localSubnetCIDR,
@@ -347,6 +408,7 @@ func (node *OsdnNode) Start() error {
 	}, time.Minute*2)
 
 	glog.V(2).Infof("openshift-sdn network plugin ready")
+	node.reportStartPhase(NodeStartPhaseReady)
 
 	// Make an event that openshift-sdn started
 	node.recorder.Eventf(&v1.ObjectReference{Kind: "Node", Name: node.hostName}, v1.EventTypeNormal, "Starting", "Starting openshift-sdn.")
@@ -362,6 +424,13 @@ func (node *OsdnNode) Start() error {
 `), 0644)
 }
 
+// DrainEgressIPs releases any egress IPs hosted on this node and refuses new
+// claims, so that callers shutting the node down for maintenance can hand the
+// addresses back before exiting.
+func (node *OsdnNode) DrainEgressIPs() {
+	node.egressIP.DrainEgressIPs()
+}
+
 // reattachPods takes an array containing the information about pods that had been
 // attached to the OVS bridge before restart, and either reattaches or kills each of the
 // corresponding pods.
@@ -0,0 +1,51 @@
+// +build linux
+
+package node
+
+import (
+	"testing"
+)
+
+func TestReportStartPhase(t *testing.T) {
+	var statuses []NodeStartStatus
+	node := &OsdnNode{
+		startStatus: func(status NodeStartStatus) {
+			statuses = append(statuses, status)
+		},
+	}
+
+	// Report the phases in the order Start does, with a non-fatal warning
+	// attached to the first
+	node.reportStartPhase(NodeStartPhaseSubnetObtained, "local networks conflict with SDN: dummy")
+	node.reportStartPhase(NodeStartPhaseFlowsInstalled)
+	node.reportStartPhase(NodeStartPhaseWatchesStarted)
+	node.reportStartPhase(NodeStartPhaseReady)
+
+	expectedPhases := []NodeStartPhase{
+		NodeStartPhaseSubnetObtained,
+		NodeStartPhaseFlowsInstalled,
+		NodeStartPhaseWatchesStarted,
+		NodeStartPhaseReady,
+	}
+	if len(statuses) != len(expectedPhases) {
+		t.Fatalf("expected %d statuses, got %#v", len(expectedPhases), statuses)
+	}
+	for i, phase := range expectedPhases {
+		if statuses[i].Phase != phase {
+			t.Errorf("expected phase %d to be %q, got %q", i, phase, statuses[i].Phase)
+		}
+	}
+
+	if len(statuses[0].Warnings) != 1 || statuses[0].Warnings[0] != "local networks conflict with SDN: dummy" {
+		t.Errorf("expected warning on first phase, got %#v", statuses[0].Warnings)
+	}
+	for i := 1; i < len(statuses); i++ {
+		if len(statuses[i].Warnings) != 0 {
+			t.Errorf("expected no warnings on phase %q, got %#v", statuses[i].Phase, statuses[i].Warnings)
+		}
+	}
+
+	// A node without a callback must not panic
+	nodeWithoutCallback := &OsdnNode{}
+	nodeWithoutCallback.reportStartPhase(NodeStartPhaseReady)
+}
@@ -604,6 +604,8 @@ func generateBaseAddServiceRule(IP string, protocol kapi.Protocol, port int) (st
 		dst = fmt.Sprintf(", udp, udp_dst=%d", port)
 	} else if protocol == kapi.ProtocolTCP {
 		dst = fmt.Sprintf(", tcp, tcp_dst=%d", port)
+	} else if protocol == kapi.ProtocolSCTP {
+		dst = fmt.Sprintf(", sctp, sctp_dst=%d", port)
 	} else {
 		return "", fmt.Errorf("unhandled protocol %v", protocol)
 	}
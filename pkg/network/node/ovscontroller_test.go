@@ -164,6 +164,62 @@ func TestOVSService(t *testing.T) {
 	}
 }
 
+func TestOVSServiceSCTP(t *testing.T) {
+	ovsif, oc, origFlows := setupOVSController(t)
+
+	svc := kapi.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "service",
+		},
+		Spec: kapi.ServiceSpec{
+			ClusterIP: "172.30.99.99",
+			Ports: []kapi.ServicePort{
+				{Protocol: kapi.ProtocolSCTP, Port: 9999},
+			},
+		},
+	}
+	err := oc.AddServiceRules(&svc, 42)
+	if err != nil {
+		t.Fatalf("Unexpected error adding service rules: %v", err)
+	}
+
+	flows, err := ovsif.DumpFlows("")
+	if err != nil {
+		t.Fatalf("Unexpected error dumping flows: %v", err)
+	}
+	err = assertFlowChanges(origFlows, flows,
+		flowChange{
+			kind:    flowAdded,
+			match:   []string{"table=60", "ip_frag", "42->NXM_NX_REG1"},
+			noMatch: []string{"sctp"},
+		},
+		flowChange{
+			kind:  flowAdded,
+			match: []string{"table=60", "nw_dst=172.30.99.99", "sctp_dst=9999", "42->NXM_NX_REG1"},
+		},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected flow changes: %v\nOrig: %#v\nNew: %#v", err, origFlows, flows)
+	}
+
+	err = oc.DeleteServiceRules(&svc)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting service rules: %v", err)
+	}
+	flows, err = ovsif.DumpFlows("")
+	if err != nil {
+		t.Fatalf("Unexpected error dumping flows: %v", err)
+	}
+	err = assertFlowChanges(origFlows, flows) // no changes
+
+	if err != nil {
+		t.Fatalf("Unexpected flow changes: %v\nOrig: %#v\nNew: %#v", err, origFlows, flows)
+	}
+}
+
 const (
 	sandboxID string = "bcb5d8d287fcf97458c48ad643b101079e3bc265a94e097e7407440716112f69"
 )
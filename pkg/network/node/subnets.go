@@ -4,6 +4,7 @@ package node
 
 import (
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/golang/glog"
@@ -129,6 +130,10 @@ func (hsw *hostSubnetWatcher) updateVXLANMulticastRules() error {
 	return hsw.oc.UpdateVXLANMulticastFlows(remoteIPs)
 }
 
+// defaultSubnetWaitTimeout is how long getLocalSubnet waits for the master to
+// allocate this node's HostSubnet when no explicit timeout is configured.
+const defaultSubnetWaitTimeout = 2 * time.Minute
+
 func (node *OsdnNode) getLocalSubnet() (string, error) {
 	var subnet *networkapi.HostSubnet
 	// If the HostSubnet doesn't already exist, it will be created by the SDN master in
@@ -136,13 +141,11 @@ func (node *OsdnNode) getLocalSubnet() (string, error) {
 	// happening in another goroutine in parallel with this). Sometimes this takes
 	// unexpectedly long though, so give it plenty of time before returning an error
 	// (since that will cause the node process to exit).
-	backoff := utilwait.Backoff{
-		// ~2 mins total
-		Duration: time.Second,
-		Factor:   1.5,
-		Steps:    11,
+	timeout := node.subnetWaitTimeout
+	if timeout == 0 {
+		timeout = defaultSubnetWaitTimeout
 	}
-	err := utilwait.ExponentialBackoff(backoff, func() (bool, error) {
+	err := utilwait.PollImmediate(time.Second, timeout, func() (bool, error) {
 		var err error
 		subnet, err = node.networkClient.Network().HostSubnets().Get(node.hostName, metav1.GetOptions{})
 		if err == nil {
@@ -162,7 +165,9 @@ func (node *OsdnNode) getLocalSubnet() (string, error) {
 			return false, err
 		}
 	})
-	if err != nil {
+	if err == utilwait.ErrWaitTimeout {
+		return "", fmt.Errorf("timed out waiting %v for a subnet for this host: %s; the master's subnet pool may be exhausted", timeout, node.hostName)
+	} else if err != nil {
 		return "", fmt.Errorf("failed to get subnet for this host: %s, error: %v", node.hostName, err)
 	}
 
@@ -170,5 +175,33 @@ func (node *OsdnNode) getLocalSubnet() (string, error) {
 		return "", fmt.Errorf("failed to validate own HostSubnet: %v", err)
 	}
 
+	if err = node.checkLocalSubnetLength(subnet.Subnet); err != nil {
+		if node.subnetLengthMismatchFatal {
+			return "", err
+		}
+		utilruntime.HandleError(err)
+	}
+
 	return subnet.Subnet, nil
 }
+
+// checkLocalSubnetLength verifies that the stored subnet's prefix length
+// matches the configured HostSubnetLength of the cluster network containing
+// it. A mismatch indicates a stale allocation from an older cluster network
+// configuration and will produce wrong OVS masks.
+func (node *OsdnNode) checkLocalSubnetLength(subnetCIDR string) error {
+	_, ipnet, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return fmt.Errorf("failed to parse local subnet %q: %v", subnetCIDR, err)
+	}
+	for _, cn := range node.networkInfo.ClusterNetworks {
+		if cn.ClusterCIDR.Contains(ipnet.IP) {
+			maskLen, _ := ipnet.Mask.Size()
+			if expected := 32 - int(cn.HostSubnetLength); maskLen != expected {
+				return fmt.Errorf("local subnet %q has prefix length /%d but cluster network %s is configured for /%d host subnets; subnet is probably a stale allocation", subnetCIDR, maskLen, cn.ClusterCIDR.String(), expected)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("local subnet %q is not part of any cluster network", subnetCIDR)
+}
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
 
 	networkapi "github.com/openshift/api/network/v1"
+	networkfake "github.com/openshift/client-go/network/clientset/versioned/fake"
 	"github.com/openshift/origin/pkg/network/common"
 )
 
@@ -340,3 +342,75 @@ func TestHostSubnetReassignment(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestGetLocalSubnetTimeout(t *testing.T) {
+	vxlanPtr := uint32(4789)
+	networkInfo, err := common.ParseNetworkInfo(
+		[]networkapi.ClusterNetworkEntry{
+			{
+				CIDR:             "10.128.0.0/14",
+				HostSubnetLength: 9,
+			},
+		},
+		"172.30.0.0/16",
+		&vxlanPtr,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error parsing network info: %v", err)
+	}
+
+	// The fake client holds no HostSubnet for the node, as when the master
+	// has exhausted its subnet pool and can never allocate one.
+	node := &OsdnNode{
+		networkClient:     networkfake.NewSimpleClientset(),
+		networkInfo:       networkInfo,
+		hostName:          "node1",
+		localIP:           "192.168.0.2",
+		subnetWaitTimeout: 50 * time.Millisecond,
+	}
+
+	start := time.Now()
+	_, err = node.getLocalSubnet()
+	if err == nil {
+		t.Fatalf("expected error waiting for subnet")
+	}
+	if !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), "exhausted") {
+		t.Errorf("expected descriptive timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the wait to respect the configured timeout, took %v", elapsed)
+	}
+}
+
+func TestCheckLocalSubnetLength(t *testing.T) {
+	vxlanPtr := uint32(4789)
+	networkInfo, err := common.ParseNetworkInfo(
+		[]networkapi.ClusterNetworkEntry{
+			{
+				CIDR:             "10.128.0.0/14",
+				HostSubnetLength: 9,
+			},
+		},
+		"172.30.0.0/16",
+		&vxlanPtr,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error parsing network info: %v", err)
+	}
+	node := &OsdnNode{networkInfo: networkInfo}
+
+	// Matching subnet length (32 - 9 = /23)
+	if err := node.checkLocalSubnetLength("10.128.2.0/23"); err != nil {
+		t.Errorf("unexpected error for matching subnet: %v", err)
+	}
+
+	// Stale allocation with the wrong prefix length
+	if err := node.checkLocalSubnetLength("10.128.2.0/24"); err == nil {
+		t.Errorf("expected error for mismatched subnet length")
+	}
+
+	// Subnet outside all cluster networks
+	if err := node.checkLocalSubnetLength("10.200.0.0/23"); err == nil {
+		t.Errorf("expected error for subnet outside cluster networks")
+	}
+}
@@ -71,6 +71,19 @@ func (vmap *nodeVNIDMap) GetNamespaces(id uint32) []string {
 	}
 }
 
+// SnapshotVNIDs returns a copy of the current namespace-to-VNID assignments,
+// so tooling can introspect them without poking at internal state.
+func (vmap *nodeVNIDMap) SnapshotVNIDs() map[string]uint32 {
+	vmap.lock.Lock()
+	defer vmap.lock.Unlock()
+
+	snapshot := make(map[string]uint32, len(vmap.ids))
+	for name, id := range vmap.ids {
+		snapshot[name] = id
+	}
+	return snapshot
+}
+
 func (vmap *nodeVNIDMap) GetMulticastEnabled(id uint32) bool {
 	vmap.lock.Lock()
 	defer vmap.lock.Unlock()
@@ -124,6 +137,13 @@ func (vmap *nodeVNIDMap) WaitAndGetVNID(name string) (uint32, error) {
 	}
 }
 
+func (vmap *nodeVNIDMap) getMCEnabled(name string) bool {
+	vmap.lock.Lock()
+	defer vmap.lock.Unlock()
+
+	return vmap.mcEnabled[name]
+}
+
 func (vmap *nodeVNIDMap) getVNID(name string) (uint32, error) {
 	vmap.lock.Lock()
 	defer vmap.lock.Unlock()
@@ -204,7 +224,7 @@ func (vmap *nodeVNIDMap) handleAddOrUpdateNetNamespace(obj, _ interface{}, event
 
 	// Skip this event if nothing has changed
 	oldNetID, err := vmap.getVNID(netns.NetName)
-	oldMCEnabled := vmap.mcEnabled[netns.NetName]
+	oldMCEnabled := vmap.getMCEnabled(netns.NetName)
 	mcEnabled := netnsIsMulticastEnabled(netns)
 	if err == nil && oldNetID == netns.NetID && oldMCEnabled == mcEnabled {
 		return
@@ -3,6 +3,8 @@
 package node
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -145,6 +147,74 @@ func TestNodeVNIDMap(t *testing.T) {
 	checkAllocatedVNIDs(t, vmap, []uint32{1, 2, 5})
 }
 
+func TestNodeVNIDMapSnapshot(t *testing.T) {
+	vmap := newNodeVNIDMap(nil, nil)
+
+	if snapshot := vmap.SnapshotVNIDs(); len(snapshot) != 0 {
+		t.Fatalf("Expected empty snapshot, got %v", snapshot)
+	}
+
+	vmap.setVNID("alpha", 1, false)
+	vmap.setVNID("bravo", 2, false)
+	vmap.setVNID("charlie", 2, false)
+
+	snapshot := vmap.SnapshotVNIDs()
+	expected := map[string]uint32{"alpha": 1, "bravo": 2, "charlie": 2}
+	if len(snapshot) != len(expected) {
+		t.Fatalf("Wrong number of entries: %v vs %v", snapshot, expected)
+	}
+	for name, id := range expected {
+		if snapshot[name] != id {
+			t.Fatalf("Expected %s=%d in snapshot, got %v", name, id, snapshot)
+		}
+	}
+
+	// Mutating the snapshot must not affect internal state
+	snapshot["delta"] = 4
+	delete(snapshot, "alpha")
+	checkExists(t, vmap, "alpha", 1)
+	checkNotExists(t, vmap, "delta")
+
+	// Revocations are reflected in later snapshots
+	if _, err := vmap.unsetVNID("bravo"); err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+	snapshot = vmap.SnapshotVNIDs()
+	if _, found := snapshot["bravo"]; found {
+		t.Fatalf("Expected bravo to be gone from snapshot, got %v", snapshot)
+	}
+	if len(snapshot) != 2 {
+		t.Fatalf("Wrong number of entries after unset: %v", snapshot)
+	}
+}
+
+// Exercises concurrent readers and writers; run with -race to catch
+// unsynchronized map access.
+func TestNodeVNIDMapConcurrent(t *testing.T) {
+	vmap := newNodeVNIDMap(nil, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("ns-%d-%d", worker, j)
+				vmap.setVNID(name, uint32(j%10+1), j%2 == 0)
+				vmap.getVNID(name)
+				vmap.getMCEnabled(name)
+				vmap.GetNamespaces(uint32(j%10 + 1))
+				vmap.GetMulticastEnabled(uint32(j%10 + 1))
+				vmap.unsetVNID(name)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	checkNamespaces(t, vmap, 1, []string{})
+	checkAllocatedVNIDs(t, vmap, []uint32{})
+}
+
 func checkExists(t *testing.T, vmap *nodeVNIDMap, name string, expected uint32) {
 	id, err := vmap.getVNID(name)
 	if id != expected || err != nil {
@@ -0,0 +1,35 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrExpired indicates a token that was once valid but is past its lifetime.
+	ErrExpired = errors.New("CSRF token expired")
+	// ErrInvalid indicates a token that does not match, e.g. due to tampering.
+	ErrInvalid = errors.New("CSRF token invalid")
+)
+
+// ExplainingCSRF is an optional extension of CSRF for implementations that can
+// distinguish why a check failed, so callers can show an accurate message.
+type ExplainingCSRF interface {
+	// CheckError returns nil for a valid token, ErrExpired for an expired one,
+	// and ErrInvalid for a mismatched one.
+	CheckError(req *http.Request, value string) error
+}
+
+// CheckError validates a token against c, reporting a typed failure when the
+// implementation supports it. Implementations that cannot distinguish report
+// every failure as ErrExpired, preserving the historical behavior of callers
+// that treated any CSRF failure as expiry.
+func CheckError(c CSRF, req *http.Request, value string) error {
+	if explaining, ok := c.(ExplainingCSRF); ok {
+		return explaining.CheckError(req, value)
+	}
+	if !c.Check(req, value) {
+		return ErrExpired
+	}
+	return nil
+}
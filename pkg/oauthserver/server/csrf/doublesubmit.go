@@ -0,0 +1,120 @@
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// doubleSubmitCsrf implements the double-submit cookie pattern: Generate sets
+// a random token in a cookie readable by the client (HttpOnly false) and
+// returns the same token for embedding in the form; Check compares the
+// submitted value to the cookie. Unlike session-backed CSRF, this requires no
+// server-side state, so it keeps working when the session store is unavailable.
+type doubleSubmitCsrf struct {
+	cookieName string
+	path       string
+	secure     bool
+
+	// maxAge bounds the token lifetime; zero means tokens never expire
+	maxAge time.Duration
+
+	// for testing
+	now func() time.Time
+}
+
+// NewDoubleSubmitCSRF returns a CSRF implementation using the double-submit
+// cookie pattern with the given cookie name and path. Tokens do not expire.
+func NewDoubleSubmitCSRF(cookieName, path string, secure bool) CSRF {
+	return NewDoubleSubmitCSRFWithExpiry(cookieName, path, secure, 0)
+}
+
+// NewDoubleSubmitCSRFWithExpiry works like NewDoubleSubmitCSRF, but tokens
+// older than maxAge fail checks with ErrExpired. A zero maxAge disables expiry.
+func NewDoubleSubmitCSRFWithExpiry(cookieName, path string, secure bool, maxAge time.Duration) CSRF {
+	return &doubleSubmitCsrf{
+		cookieName: cookieName,
+		path:       path,
+		secure:     secure,
+		maxAge:     maxAge,
+		now:        time.Now,
+	}
+}
+
+func (c *doubleSubmitCsrf) Generate(w http.ResponseWriter, req *http.Request) string {
+	// Reuse an existing unexpired token so multiple tabs don't invalidate
+	// each other
+	if cookie, err := req.Cookie(c.cookieName); err == nil && len(cookie.Value) > 0 && !c.isExpired(cookie.Value) {
+		return cookie.Value
+	}
+
+	token, err := c.newToken()
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to generate CSRF token: %v", err))
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:  c.cookieName,
+		Value: token,
+		Path:  c.path,
+		// The client must be able to read the cookie to double-submit it
+		HttpOnly: false,
+		Secure:   c.secure,
+	})
+	return token
+}
+
+func (c *doubleSubmitCsrf) Check(req *http.Request, value string) bool {
+	return c.CheckError(req, value) == nil
+}
+
+func (c *doubleSubmitCsrf) CheckError(req *http.Request, value string) error {
+	if len(value) == 0 {
+		return ErrInvalid
+	}
+	cookie, err := req.Cookie(c.cookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return ErrInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(value)) != 1 {
+		return ErrInvalid
+	}
+	if c.isExpired(value) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// newToken returns an issue-timestamp-prefixed random token, so expiry can be
+// checked without server-side state.
+func (c *doubleSubmitCsrf) newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%s", c.now().Unix(), base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+// isExpired reports whether the token's issue timestamp is older than maxAge.
+// Malformed tokens are treated as expired so they get reissued.
+func (c *doubleSubmitCsrf) isExpired(token string) bool {
+	if c.maxAge == 0 {
+		return false
+	}
+	i := strings.Index(token, ".")
+	if i <= 0 {
+		return true
+	}
+	issued, err := strconv.ParseInt(token[:i], 10, 64)
+	if err != nil {
+		return true
+	}
+	return c.now().After(time.Unix(issued, 0).Add(c.maxAge))
+}
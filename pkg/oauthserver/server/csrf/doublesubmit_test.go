@@ -0,0 +1,92 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoubleSubmitCSRF(t *testing.T) {
+	csrf := NewDoubleSubmitCSRF("csrf", "/", false)
+
+	// Generate must set a cookie carrying the returned token
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "https://example.com/login", nil)
+	token := csrf.Generate(w, req)
+	if len(token) == 0 {
+		t.Fatalf("expected a token to be generated")
+	}
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].Value != token {
+		t.Fatalf("expected a cookie matching the token, got %#v", cookies)
+	}
+	if cookies[0].HttpOnly {
+		t.Errorf("double-submit cookie must be readable by the client")
+	}
+
+	// Generate must reuse an existing token instead of rotating it
+	req2, _ := http.NewRequest("GET", "https://example.com/login", nil)
+	req2.AddCookie(&http.Cookie{Name: "csrf", Value: token})
+	if reused := csrf.Generate(httptest.NewRecorder(), req2); reused != token {
+		t.Errorf("expected existing token %q to be reused, got %q", token, reused)
+	}
+
+	// Check succeeds when the form value matches the cookie
+	post, _ := http.NewRequest("POST", "https://example.com/login", nil)
+	post.AddCookie(&http.Cookie{Name: "csrf", Value: token})
+	if !csrf.Check(post, token) {
+		t.Errorf("expected check to succeed for matching cookie and value")
+	}
+
+	// A mismatched value is rejected
+	if csrf.Check(post, "other") {
+		t.Errorf("expected check to fail for mismatched value")
+	}
+
+	// A missing cookie is rejected
+	bare, _ := http.NewRequest("POST", "https://example.com/login", nil)
+	if csrf.Check(bare, token) {
+		t.Errorf("expected check to fail without a cookie")
+	}
+
+	// An empty value is rejected even with an empty cookie
+	empty, _ := http.NewRequest("POST", "https://example.com/login", nil)
+	empty.AddCookie(&http.Cookie{Name: "csrf", Value: ""})
+	if csrf.Check(empty, "") {
+		t.Errorf("expected check to fail for empty value")
+	}
+}
+
+func TestDoubleSubmitCSRFExpiry(t *testing.T) {
+	now := time.Now()
+	csrf := NewDoubleSubmitCSRFWithExpiry("csrf", "/", false, time.Hour).(*doubleSubmitCsrf)
+	csrf.now = func() time.Time { return now }
+
+	req, _ := http.NewRequest("GET", "https://example.com/login", nil)
+	token := csrf.Generate(httptest.NewRecorder(), req)
+
+	post, _ := http.NewRequest("POST", "https://example.com/login", nil)
+	post.AddCookie(&http.Cookie{Name: "csrf", Value: token})
+
+	// A fresh token passes
+	if err := CheckError(csrf, post, token); err != nil {
+		t.Errorf("expected fresh token to pass, got %v", err)
+	}
+
+	// A mismatched token is invalid, not expired
+	if err := CheckError(csrf, post, "other"); err != ErrInvalid {
+		t.Errorf("expected %v for mismatched token, got %v", ErrInvalid, err)
+	}
+
+	// Past its lifetime the same token reports expiry
+	now = now.Add(2 * time.Hour)
+	if err := CheckError(csrf, post, token); err != ErrExpired {
+		t.Errorf("expected %v for expired token, got %v", ErrExpired, err)
+	}
+
+	// An expired cookie is not reused by Generate
+	if reissued := csrf.Generate(httptest.NewRecorder(), post); reissued == token || len(reissued) == 0 {
+		t.Errorf("expected an expired token to be reissued, got %q", reissued)
+	}
+}
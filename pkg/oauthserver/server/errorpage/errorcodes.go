@@ -26,6 +26,16 @@ func AuthenticationErrorCode(err error) string {
 	}
 }
 
+// KnownAuthenticationErrorCode returns true if the given code is one this
+// package maps to a specific authentication error message.
+func KnownAuthenticationErrorCode(code string) bool {
+	switch code {
+	case errorCodeClaim, errorCodeLookup, errorCodeAuthentication:
+		return true
+	}
+	return false
+}
+
 // AuthenticationErrorMessage returns an error message for the given authentication error code.
 // If the error code is not recognized, a generic error message is returned.
 func AuthenticationErrorMessage(code string) string {
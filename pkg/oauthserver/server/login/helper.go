@@ -21,6 +21,22 @@ func failed(reason string, w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, uri.String(), http.StatusFound)
 }
 
+// sanitizeReason makes an unrecognized reason value safe to display by
+// dropping non-printable characters and capping its length. Template
+// rendering handles HTML escaping.
+func sanitizeReason(reason string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 {
+			return -1
+		}
+		return r
+	}, reason)
+	if len(sanitized) > maxReasonLength {
+		sanitized = sanitized[:maxReasonLength]
+	}
+	return sanitized
+}
+
 func getBaseURL(req *http.Request) (*url.URL, error) {
 	uri, err := url.Parse(req.RequestURI)
 	if err != nil {
@@ -0,0 +1,107 @@
+package login
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginLockout tracks consecutive authentication failures per username and
+// reports when an account is temporarily locked out. While locked, login
+// attempts are refused before the authenticator is consulted, even if the
+// submitted credentials are correct.
+type LoginLockout interface {
+	// IsLockedOut reports whether attempts for username are currently refused.
+	IsLockedOut(username string) bool
+	// RecordFailure notes a failed attempt for username.
+	RecordFailure(username string)
+	// RecordSuccess clears the failure count for username.
+	RecordSuccess(username string)
+}
+
+// memoryLockout is an in-memory LoginLockout. Once threshold consecutive
+// failures are recorded for a username, the account is locked for duration.
+// Entries idle longer than duration past their last failure are evicted.
+type memoryLockout struct {
+	lock sync.Mutex
+
+	threshold int
+	duration  time.Duration
+	entries   map[string]*lockoutEntry
+
+	// for testing
+	now func() time.Time
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewMemoryLockout returns a LoginLockout locking accounts for duration after
+// threshold consecutive failures.
+func NewMemoryLockout(threshold int, duration time.Duration) LoginLockout {
+	return &memoryLockout{
+		threshold: threshold,
+		duration:  duration,
+		entries:   make(map[string]*lockoutEntry),
+		now:       time.Now,
+	}
+}
+
+func (m *memoryLockout) IsLockedOut(username string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entry := m.entries[username]
+	if entry == nil {
+		return false
+	}
+	now := m.now()
+	if m.expired(entry, now) {
+		delete(m.entries, username)
+		return false
+	}
+	return entry.lockedUntil.After(now)
+}
+
+func (m *memoryLockout) RecordFailure(username string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	m.evictExpired(now)
+
+	entry := m.entries[username]
+	if entry == nil {
+		entry = &lockoutEntry{}
+		m.entries[username] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	if entry.failures >= m.threshold {
+		entry.lockedUntil = now.Add(m.duration)
+	}
+}
+
+func (m *memoryLockout) RecordSuccess(username string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.entries, username)
+}
+
+// expired reports whether an entry is past both its lock and its idle TTL.
+func (m *memoryLockout) expired(entry *lockoutEntry, now time.Time) bool {
+	return now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > m.duration
+}
+
+// evictExpired drops stale entries so the map does not grow without bound.
+// Callers must hold the lock.
+func (m *memoryLockout) evictExpired(now time.Time) {
+	for username, entry := range m.entries {
+		if m.expired(entry, now) {
+			delete(m.entries, username)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/origin/pkg/oauthserver/server/csrf"
+)
+
+func TestMemoryLockout(t *testing.T) {
+	now := time.Now()
+	lockout := NewMemoryLockout(3, time.Minute).(*memoryLockout)
+	lockout.now = func() time.Time { return now }
+
+	// Below the threshold nothing is locked
+	lockout.RecordFailure("a")
+	lockout.RecordFailure("a")
+	if lockout.IsLockedOut("a") {
+		t.Fatalf("account locked below the failure threshold")
+	}
+
+	// Crossing the threshold locks the account
+	lockout.RecordFailure("a")
+	if !lockout.IsLockedOut("a") {
+		t.Fatalf("account not locked at the failure threshold")
+	}
+
+	// Other accounts are unaffected
+	if lockout.IsLockedOut("b") {
+		t.Fatalf("unrelated account unexpectedly locked")
+	}
+
+	// The lock expires after the configured duration
+	now = now.Add(2 * time.Minute)
+	if lockout.IsLockedOut("a") {
+		t.Fatalf("account still locked after the lockout duration")
+	}
+
+	// A success resets the failure count
+	lockout.RecordFailure("c")
+	lockout.RecordFailure("c")
+	lockout.RecordSuccess("c")
+	lockout.RecordFailure("c")
+	if lockout.IsLockedOut("c") {
+		t.Fatalf("account locked despite success resetting the count")
+	}
+
+	// Stale entries are evicted
+	lockout.RecordFailure("d")
+	now = now.Add(2 * time.Minute)
+	lockout.RecordFailure("e")
+	if _, ok := lockout.entries["d"]; ok {
+		t.Fatalf("stale entry was not evicted")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	loginFormRenderer, err := NewLoginFormRenderer("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Now()
+	lockout := NewMemoryLockout(2, time.Minute).(*memoryLockout)
+	lockout.now = func() time.Time { return now }
+	auth := &testAuth{Success: false}
+	login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, auth, loginFormRenderer, LoginOptions{Lockout: lockout})
+	server := httptest.NewServer(login)
+
+	attempt := func(password string) *http.Response {
+		resp, err := postForm(server.URL+"/login", url.Values{
+			"csrf":     []string{"test"},
+			"username": []string{"user"},
+			"password": []string{password},
+			"then":     []string{"/done"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Two failures lock the account
+	attempt("wrong")
+	attempt("wrong")
+
+	// The authenticator is no longer consulted while locked, even with
+	// correct credentials
+	auth.Success = true
+	auth.User = &user.DefaultInfo{Name: "user"}
+	auth.Username = ""
+	resp := attempt("pass")
+	if auth.Username != "" {
+		t.Errorf("authenticator must not be called while locked")
+	}
+	uri, err := resp.Location()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uri.String() != server.URL+"/login?reason=account_locked&then=%2Fdone" {
+		t.Errorf("unexpected redirect: %s", uri.String())
+	}
+
+	// After the lock expires, a correct login succeeds
+	now = now.Add(2 * time.Minute)
+	attempt("pass")
+	if !auth.Called || auth.Then != "/done" {
+		t.Errorf("expected login to succeed after lock expiry: %#v", auth)
+	}
+}
@@ -2,15 +2,22 @@ package login
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 
 	"github.com/openshift/origin/pkg/oauthserver"
@@ -28,21 +35,92 @@ const (
 	usernameParam = "username"
 	passwordParam = "password"
 	reasonParam   = "reason"
+	rememberParam = "remember"
+
+	// csrfHeader may carry the token for the CSRF validity check endpoint
+	csrfHeader = "X-CSRF-Token"
+
+	// defaultRequestIDHeader carries the correlation ID when request IDs are
+	// enabled and no custom header name is configured
+	defaultRequestIDHeader = "X-Request-Id"
+
+	// defaults for the remember-username cookie
+	defaultRememberUsernameCookieName   = "openshift_remembered_username"
+	defaultRememberUsernameCookieMaxAge = 30 * 24 * time.Hour
+
+	// defaultContentSecurityPolicy locks login responses down to same-origin
+	// resources. Inline styles are allowed because the built-in template uses
+	// them; custom templates that load external fonts or CSS must override it.
+	defaultContentSecurityPolicy = "default-src 'self'; style-src 'self' 'unsafe-inline'"
+
+	// default autocomplete hints for the credential inputs. These match what
+	// browsers infer for a bare login form, so the zero config keeps the
+	// historical behavior.
+	defaultUsernameAutocomplete = "username"
+	defaultPasswordAutocomplete = "current-password"
+
+	// maxReasonLength bounds how much of an unrecognized reason value is echoed
+	maxReasonLength = 256
+
+	// defaultMaxRequestBodySize bounds login POST bodies. Login forms only
+	// carry a username, password, CSRF token and redirect target, so this is
+	// generous for legitimate clients while stopping oversized bodies from
+	// being buffered during form parsing.
+	defaultMaxRequestBodySize = 8 * 1024
 
 	// these can be used by custom templates, and should not be changed
 	// these error codes are specific to the login flow.
 	// general authentication error codes are found in the errorpage package
-	errorCodeUserRequired = "user_required"
-	errorCodeTokenExpired = "token_expired"
-	errorCodeAccessDenied = "access_denied"
+	errorCodeUserRequired  = "user_required"
+	errorCodeTokenExpired  = "token_expired"
+	errorCodeTokenInvalid  = "token_invalid"
+	errorCodeAccessDenied  = "access_denied"
+	errorCodeAccountLocked = "account_locked"
+	errorCodeThrottled     = "too_many_attempts"
 )
 
 // Error messages that correlate to the error codes above.
 // General authentication error messages are found in the error page package
 var errorMessages = map[string]string{
-	errorCodeUserRequired: "Login is required. Please try again.",
-	errorCodeTokenExpired: "Could not check CSRF token. Please try again.",
-	errorCodeAccessDenied: "Invalid login or password. Please try again.",
+	errorCodeUserRequired:  "Login is required. Please try again.",
+	errorCodeTokenExpired:  "Could not check CSRF token. Please try again.",
+	errorCodeTokenInvalid:  "Invalid CSRF token. Please try again.",
+	errorCodeAccessDenied:  "Invalid login or password. Please try again.",
+	errorCodeAccountLocked: "Account is temporarily locked due to repeated failed logins. Please try again later.",
+	errorCodeThrottled:     "Too many login attempts. Please wait and try again.",
+}
+
+// LoginAuditor receives the outcome of every login POST that passes CSRF
+// validation, so operators can stream authentication attempts to a SIEM.
+// reason is the error code for the failed branch, or empty on success.
+type LoginAuditor interface {
+	RecordAttempt(username, clientIP string, success bool, reason string)
+}
+
+// LoginEvent describes a single login flow event with structured fields, for
+// deployments that ingest JSON logs rather than glog's free-form strings.
+type LoginEvent struct {
+	// Event is "form_render" for GETs that render the login form and
+	// "login_attempt" for POSTs that pass CSRF validation.
+	Event string
+	// Username is the submitted username; empty for form renders.
+	Username string
+	// Reason is the error code shown or redirected to; empty on success.
+	Reason string
+	// Success is only meaningful for login attempts.
+	Success bool
+	// ClientIP is the client IP as determined by the configured
+	// SourceIPExtractor, or the request's RemoteAddr.
+	ClientIP string
+	// RequestID is the correlation ID of the request, when enabled.
+	RequestID string
+}
+
+// LoginLogger receives structured login flow events. The glog output is
+// unaffected, so deployments without a structured sink keep the historical
+// behavior.
+type LoginLogger interface {
+	LogLoginEvent(event LoginEvent)
 }
 
 type PasswordAuthenticator interface {
@@ -57,11 +135,31 @@ type LoginFormRenderer interface {
 type LoginForm struct {
 	ProviderName string
 
+	// LogoURL is an optional image URL custom templates can render for
+	// branding. Empty unless configured.
+	LogoURL string
+
 	Action string
 
 	Error     string
 	ErrorCode string
 
+	// Lang is the language tag of the localized template serving the request,
+	// if any, so templates can set <html lang>. Empty for the default template.
+	Lang string
+
+	// Extra holds allowlisted query parameters carried through the login flow.
+	// Custom templates should render them as hidden inputs:
+	//   {{ range $name, $value := .Extra }}<input type="hidden" name="{{ $name }}" value="{{ $value }}">{{ end }}
+	Extra map[string]string
+
+	// UsernameAutocomplete and PasswordAutocomplete are the autocomplete
+	// attribute values custom templates should render on the credential
+	// inputs:
+	//   <input name="{{ .Names.Username }}" autocomplete="{{ .UsernameAutocomplete }}">
+	UsernameAutocomplete string
+	PasswordAutocomplete string
+
 	Names  LoginFormFields
 	Values LoginFormFields
 }
@@ -71,6 +169,144 @@ type LoginFormFields struct {
 	CSRF     string
 	Username string
 	Password string
+
+	// Remember is the name (and, on the values side, the checked state) of
+	// the "remember username" checkbox. Only populated when the feature is
+	// enabled.
+	Remember string
+}
+
+// UnknownReasonPolicy controls how the login form treats reason query values
+// that do not correspond to a known error code. Third parties can craft links
+// with arbitrary reason values, so by default we only show a generic message.
+type UnknownReasonPolicy string
+
+const (
+	// UnknownReasonGeneric renders the generic authentication error message.
+	// This is the default behavior.
+	UnknownReasonGeneric UnknownReasonPolicy = "generic"
+	// UnknownReasonSuppress omits the error banner entirely for unrecognized codes.
+	UnknownReasonSuppress UnknownReasonPolicy = "suppress"
+	// UnknownReasonEcho renders the sanitized raw reason value.
+	// This is only intended for debugging custom login flows.
+	UnknownReasonEcho UnknownReasonPolicy = "echo"
+)
+
+// RememberUsernameCookie configures the optional cookie that pre-fills the
+// username field on later visits. Only the username is ever stored; the
+// password never is.
+type RememberUsernameCookie struct {
+	// Name of the cookie. Empty means "openshift_remembered_username".
+	Name string
+	// MaxAge is the cookie lifetime. Zero means 30 days.
+	MaxAge time.Duration
+	// Secure restricts the cookie to HTTPS.
+	Secure bool
+}
+
+// LoginOptions holds optional behavior for the login handler.
+// The zero value preserves the historical defaults.
+type LoginOptions struct {
+	// UnknownReasonPolicy determines what the login form displays when the
+	// reason query parameter is not a recognized error code.
+	UnknownReasonPolicy UnknownReasonPolicy
+
+	// FailClosedOnError causes authenticator errors (as opposed to clean
+	// denials) to be surfaced as 503 responses instead of redirecting back to
+	// the form with a generic message, so monitoring can distinguish an
+	// auth-backend outage from bad credentials. The user retries via the GET
+	// form, which issues a fresh CSRF token.
+	FailClosedOnError bool
+
+	// MaxRequestBodySize bounds how many bytes of a login POST body are read
+	// before the request is rejected with a 413. Zero means the default.
+	MaxRequestBodySize int64
+
+	// SourceIPExtractor determines the client IP recorded for login attempts.
+	// Nil means the request's RemoteAddr is always used.
+	SourceIPExtractor *SourceIPExtractor
+
+	// RateLimiter throttles login POSTs, keyed by client IP. Nil means no
+	// throttling.
+	RateLimiter LoginRateLimiter
+
+	// RateLimitByUsername additionally keys the rate limiter by the submitted
+	// username, so one abusive client cannot exhaust another user's budget.
+	RateLimitByUsername bool
+
+	// Auditor, if set, is told the outcome of every login POST that passes
+	// CSRF validation.
+	Auditor LoginAuditor
+
+	// Logger, if set, receives structured events for form renders and login
+	// attempts. Nil keeps glog-only logging.
+	Logger LoginLogger
+
+	// LogoURL is an optional image URL passed through to custom login
+	// templates as {{ .LogoURL }}.
+	LogoURL string
+
+	// DefaultThen is the server-relative URL users are sent to after logging
+	// in when no "then" parameter is supplied. Empty preserves the historical
+	// behavior of redirecting such requests to "/". Values that are not
+	// server-relative are ignored at construction.
+	DefaultThen string
+
+	// UsernameAutocomplete and PasswordAutocomplete override the
+	// autocomplete hints passed to login templates. Empty means "username"
+	// and "current-password" respectively; security policies that forbid
+	// credential autofill can set them to "off".
+	UsernameAutocomplete string
+	PasswordAutocomplete string
+
+	// EnableRequestID assigns a correlation ID to every login request. The
+	// incoming RequestIDHeader value is honored when present and a new ID is
+	// generated otherwise; either way the ID is echoed in the response header
+	// and included in log and audit output, so a failed login can be traced
+	// across proxy and OAuth server logs.
+	EnableRequestID bool
+
+	// RequestIDHeader is the header the correlation ID is read from and
+	// echoed in. Empty means "X-Request-Id". Only consulted when
+	// EnableRequestID is set.
+	RequestIDHeader string
+
+	// ReasonMessages maps reason codes to messages shown on the login form,
+	// consulted before the built-in messages. This lets identity providers
+	// redirect back with provider-specific reasons (e.g. password_expired)
+	// or override the built-in wording.
+	ReasonMessages map[string]string
+
+	// PreservedQueryParams is an allowlist of extra query parameter names
+	// (e.g. client_id, state) carried from the login GET through the POST and
+	// appended to the "then" URL on success.
+	PreservedQueryParams []string
+
+	// Lockout, if set, refuses attempts for accounts with too many
+	// consecutive failures before the authenticator is consulted.
+	Lockout LoginLockout
+
+	// ErrorStatusCode is the HTTP status RenderError responds with. Zero
+	// means 200, so intermediaries serve the page as regular content.
+	ErrorStatusCode int
+
+	// ContentSecurityPolicy is the Content-Security-Policy header value for
+	// login responses. Empty means a restrictive same-origin default.
+	ContentSecurityPolicy string
+
+	// StrictTransportSecurity, if set, is the Strict-Transport-Security
+	// header value sent on login responses (eg "max-age=31536000"). Empty
+	// sends no HSTS header, preserving the historical behavior.
+	StrictTransportSecurity string
+
+	// FrameOptions is the X-Frame-Options header value for login responses.
+	// Empty means "DENY".
+	FrameOptions string
+
+	// RememberUsername, if set, enables a cookie that pre-fills the username
+	// field when the user checks the "remember" checkbox on the form. Nil
+	// disables the feature.
+	RememberUsername *RememberUsernameCookie
 }
 
 type Login struct {
@@ -78,14 +314,27 @@ type Login struct {
 	csrf     csrf.CSRF
 	auth     PasswordAuthenticator
 	render   LoginFormRenderer
+	options  LoginOptions
 }
 
 func NewLogin(provider string, csrf csrf.CSRF, auth PasswordAuthenticator, render LoginFormRenderer) *Login {
+	return NewLoginWithOptions(provider, csrf, auth, render, LoginOptions{})
+}
+
+func NewLoginWithOptions(provider string, csrf csrf.CSRF, auth PasswordAuthenticator, render LoginFormRenderer, options LoginOptions) *Login {
+	if len(options.DefaultThen) > 0 && !redirect.IsServerRelativeURL(options.DefaultThen) {
+		utilruntime.HandleError(fmt.Errorf("DefaultThen %q is not a server-relative URL, ignoring", options.DefaultThen))
+		options.DefaultThen = ""
+	}
+	if options.EnableRequestID && len(options.RequestIDHeader) == 0 {
+		options.RequestIDHeader = defaultRequestIDHeader
+	}
 	return &Login{
 		provider: provider,
 		csrf:     csrf,
 		auth:     auth,
 		render:   render,
+		options:  options,
 	}
 }
 
@@ -98,12 +347,62 @@ func (l *Login) Install(mux oauthserver.Mux, paths ...string) {
 	}
 }
 
+// InstallTokenCheck registers a lightweight endpoint that reports whether a
+// CSRF token is still valid without consuming it, rendering the form, or
+// authenticating. This lets single-page apps probe a stored token before
+// showing the login form. It is only registered when explicitly installed.
+func (l *Login) InstallTokenCheck(mux oauthserver.Mux, paths ...string) {
+	for _, path := range paths {
+		path = strings.TrimRight(path, "/")
+		mux.HandleFunc(path, l.handleTokenCheck)
+	}
+}
+
+// InstallHealth registers a cheap liveness endpoint for load balancers. It
+// returns a tiny JSON body and never touches CSRF state or templates.
+func (l *Login) InstallHealth(mux oauthserver.Mux, path string) {
+	mux.HandleFunc(strings.TrimRight(path, "/"), handleHealth)
+}
+
+func handleHealth(w http.ResponseWriter, req *http.Request) {
+	headers.SetStandardHeaders(w)
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, `{"status":"ok"}`)
+}
+
+func (l *Login) handleTokenCheck(w http.ResponseWriter, req *http.Request) {
+	headers.SetStandardHeaders(w)
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := req.Header.Get(csrfHeader)
+	if len(token) == 0 {
+		token = req.URL.Query().Get(csrfParam)
+	}
+	// Check only inspects the request and the supplied token; it does not
+	// generate or invalidate anything, so probing here is side-effect free.
+	if ok := l.csrf.Check(req, token); !ok {
+		http.Error(w, "CSRF token invalid", http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (l *Login) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	headers.SetStandardHeaders(w)
+	l.setSecurityHeaders(w)
+	l.ensureRequestID(w, req)
 	switch req.Method {
 	case http.MethodGet:
 		l.handleLoginForm(w, req)
 	case http.MethodPost:
+		req.Body = http.MaxBytesReader(w, req.Body, l.maxRequestBodySize())
 		l.handleLogin(w, req)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -119,8 +418,11 @@ func (l *Login) handleLoginForm(w http.ResponseWriter, req *http.Request) {
 	}
 
 	form := LoginForm{
-		ProviderName: l.provider,
-		Action:       uri.String(),
+		ProviderName:         l.provider,
+		LogoURL:              l.options.LogoURL,
+		Action:               uri.String(),
+		UsernameAutocomplete: l.usernameAutocomplete(),
+		PasswordAutocomplete: l.passwordAutocomplete(),
 		Names: LoginFormFields{
 			Then:     thenParam,
 			CSRF:     csrfParam,
@@ -128,74 +430,496 @@ func (l *Login) handleLoginForm(w http.ResponseWriter, req *http.Request) {
 			Password: passwordParam,
 		},
 	}
-	if then := req.URL.Query().Get(thenParam); redirect.IsServerRelativeURL(then) {
+	then := req.URL.Query().Get(thenParam)
+	if len(then) == 0 {
+		then = l.options.DefaultThen
+	}
+	if redirect.IsServerRelativeURL(then) {
 		form.Values.Then = then
 	} else {
 		http.Redirect(w, req, "/", http.StatusFound)
 		return
 	}
 
+	if l.options.RememberUsername != nil {
+		form.Names.Remember = rememberParam
+		if username, ok := l.rememberedUsername(req); ok {
+			form.Values.Username = username
+			form.Values.Remember = "true"
+		}
+	}
+
+	for _, name := range l.options.PreservedQueryParams {
+		if value := req.URL.Query().Get(name); len(value) > 0 {
+			if form.Extra == nil {
+				form.Extra = map[string]string{}
+			}
+			form.Extra[name] = value
+		}
+	}
+
 	form.ErrorCode = req.URL.Query().Get(reasonParam)
 	if len(form.ErrorCode) > 0 {
-		if msg, hasMsg := errorMessages[form.ErrorCode]; hasMsg {
+		if msg, hasMsg := l.errorMessage(form.ErrorCode); hasMsg {
 			form.Error = msg
-		} else {
+		} else if errorpage.KnownAuthenticationErrorCode(form.ErrorCode) {
 			form.Error = errorpage.AuthenticationErrorMessage(form.ErrorCode)
+		} else {
+			switch l.options.UnknownReasonPolicy {
+			case UnknownReasonSuppress:
+				form.Error = ""
+				form.ErrorCode = ""
+			case UnknownReasonEcho:
+				form.Error = sanitizeReason(form.ErrorCode)
+			default:
+				form.Error = errorpage.AuthenticationErrorMessage(form.ErrorCode)
+			}
 		}
 	}
 
 	form.Values.CSRF = l.csrf.Generate(w, req)
 
+	l.logEvent("form_render", "", form.ErrorCode, false, req)
+	if wantsJSONResponse(req) {
+		l.writeFormJSON(w, form)
+		return
+	}
 	l.render.Render(form, w, req)
 }
 
+// loginFormJSON is the rendering of LoginForm served to programmatic clients
+// that ask for JSON. The password value is never included.
+type loginFormJSON struct {
+	ProviderName string            `json:"providerName,omitempty"`
+	Action       string            `json:"action"`
+	Error        string            `json:"error,omitempty"`
+	ErrorCode    string            `json:"errorCode,omitempty"`
+	Extra        map[string]string `json:"extra,omitempty"`
+
+	Names  loginFieldsJSON `json:"names"`
+	Values loginFieldsJSON `json:"values"`
+}
+
+type loginFieldsJSON struct {
+	Then     string `json:"then,omitempty"`
+	CSRF     string `json:"csrf,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// wantsJSONResponse reports whether the client explicitly asked for a JSON
+// rendering of the login form. HTML remains the default; only an Accept
+// header naming application/json opts in.
+func wantsJSONResponse(req *http.Request) bool {
+	for _, entry := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(entry)
+		if i := strings.Index(mediaType, ";"); i >= 0 {
+			mediaType = strings.TrimSpace(mediaType[:i])
+		}
+		if mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Login) writeFormJSON(w http.ResponseWriter, form LoginForm) {
+	data, err := json.Marshal(loginFormJSON{
+		ProviderName: form.ProviderName,
+		Action:       form.Action,
+		Error:        form.Error,
+		ErrorCode:    form.ErrorCode,
+		Extra:        form.Extra,
+		Names: loginFieldsJSON{
+			Then:     form.Names.Then,
+			CSRF:     form.Names.CSRF,
+			Username: form.Names.Username,
+			Password: form.Names.Password,
+		},
+		Values: loginFieldsJSON{
+			Then:     form.Values.Then,
+			CSRF:     form.Values.CSRF,
+			Username: form.Values.Username,
+		},
+	})
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to marshal login form: %v", err))
+		http.Error(w, "Unable to render login form", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write login form: %v", err))
+	}
+}
+
+// RenderError renders the login template with message as the error banner and
+// no form action or CSRF token, so flows outside the form submission (eg an
+// external identity provider returning an error) can show a branded page
+// without offering a usable login form. The status code defaults to 200 and
+// can be changed via ErrorStatusCode.
+func (l *Login) RenderError(w http.ResponseWriter, req *http.Request, message string) {
+	headers.SetStandardHeaders(w)
+	l.setSecurityHeaders(w)
+	form := LoginForm{
+		ProviderName:         l.provider,
+		LogoURL:              l.options.LogoURL,
+		Error:                message,
+		UsernameAutocomplete: l.usernameAutocomplete(),
+		PasswordAutocomplete: l.passwordAutocomplete(),
+	}
+	if code := l.options.ErrorStatusCode; code != 0 && code != http.StatusOK {
+		w = &statusOverrideWriter{ResponseWriter: w, status: code}
+	}
+	l.render.Render(form, w, req)
+}
+
+// statusOverrideWriter replaces the renderer's 200 status with the configured
+// one, while leaving other statuses (eg a render failure's 500) untouched.
+type statusOverrideWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusOverrideWriter) WriteHeader(code int) {
+	if code == http.StatusOK {
+		code = w.status
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// renderThrottled responds to a throttled login POST with the login error
+// template (or the negotiated JSON body) and status 429. The other failure
+// paths redirect back to the form, but a redirect would lose the status, so
+// the page is rendered directly with no form action or CSRF token, like
+// RenderError.
+func (l *Login) renderThrottled(w http.ResponseWriter, req *http.Request) {
+	msg, _ := l.errorMessage(errorCodeThrottled)
+	form := LoginForm{
+		ProviderName:         l.provider,
+		LogoURL:              l.options.LogoURL,
+		Error:                msg,
+		ErrorCode:            errorCodeThrottled,
+		UsernameAutocomplete: l.usernameAutocomplete(),
+		PasswordAutocomplete: l.passwordAutocomplete(),
+	}
+	w = &statusOverrideWriter{ResponseWriter: w, status: http.StatusTooManyRequests}
+	if wantsJSONResponse(req) {
+		l.writeFormJSON(w, form)
+		return
+	}
+	l.render.Render(form, w, req)
+}
+
+// appendPreservedParams copies allowlisted form values onto the "then" URL so
+// they survive the login round trip. The URL is returned unchanged when no
+// allowlisted parameter was submitted or it cannot be parsed.
+func (l *Login) appendPreservedParams(then string, req *http.Request) string {
+	if len(l.options.PreservedQueryParams) == 0 {
+		return then
+	}
+	thenURL, err := url.Parse(then)
+	if err != nil {
+		return then
+	}
+	query := thenURL.Query()
+	changed := false
+	for _, name := range l.options.PreservedQueryParams {
+		if value := req.FormValue(name); len(value) > 0 {
+			query.Set(name, value)
+			changed = true
+		}
+	}
+	if !changed {
+		return then
+	}
+	thenURL.RawQuery = query.Encode()
+	return thenURL.String()
+}
+
+// errorMessage resolves a reason code to a message, preferring configured
+// messages over the built-in defaults.
+func (l *Login) errorMessage(code string) (string, bool) {
+	if msg, hasMsg := l.options.ReasonMessages[code]; hasMsg {
+		return msg, true
+	}
+	msg, hasMsg := errorMessages[code]
+	return msg, hasMsg
+}
+
+func (l *Login) rememberUsernameCookieName() string {
+	if len(l.options.RememberUsername.Name) > 0 {
+		return l.options.RememberUsername.Name
+	}
+	return defaultRememberUsernameCookieName
+}
+
+// rememberedUsername returns the username stored by a previous login with the
+// remember checkbox set, if the feature is enabled and the cookie is present.
+func (l *Login) rememberedUsername(req *http.Request) (string, bool) {
+	if l.options.RememberUsername == nil {
+		return "", false
+	}
+	cookie, err := req.Cookie(l.rememberUsernameCookieName())
+	if err != nil {
+		return "", false
+	}
+	username, err := url.QueryUnescape(cookie.Value)
+	if err != nil || len(username) == 0 {
+		return "", false
+	}
+	return username, true
+}
+
+// updateRememberedUsername stores the username after a successful login when
+// the remember checkbox was submitted, and clears any stored username when it
+// was not, so unchecking the box forgets the name. Only the username is
+// stored; the password never is.
+func (l *Login) updateRememberedUsername(w http.ResponseWriter, req *http.Request, username string) {
+	config := l.options.RememberUsername
+	if config == nil {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     l.rememberUsernameCookieName(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   config.Secure,
+	}
+	if len(req.FormValue(rememberParam)) > 0 {
+		maxAge := config.MaxAge
+		if maxAge == 0 {
+			maxAge = defaultRememberUsernameCookieMaxAge
+		}
+		cookie.Value = url.QueryEscape(username)
+		cookie.MaxAge = int(maxAge / time.Second)
+	} else {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// setSecurityHeaders applies the browser security headers to a login
+// response, using the configured values where set and safe defaults
+// otherwise.
+func (l *Login) setSecurityHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	frameOptions := l.options.FrameOptions
+	if len(frameOptions) == 0 {
+		frameOptions = "DENY"
+	}
+	w.Header().Set("X-Frame-Options", frameOptions)
+
+	csp := l.options.ContentSecurityPolicy
+	if len(csp) == 0 {
+		csp = defaultContentSecurityPolicy
+	}
+	w.Header().Set("Content-Security-Policy", csp)
+
+	if len(l.options.StrictTransportSecurity) > 0 {
+		w.Header().Set("Strict-Transport-Security", l.options.StrictTransportSecurity)
+	}
+}
+
+// ensureRequestID resolves the correlation ID for a request when request IDs
+// are enabled. The incoming header value is honored when present and a new ID
+// is generated otherwise; the ID is stored back on the request so later log
+// calls find it, and echoed in the response header so clients and proxies can
+// correlate.
+func (l *Login) ensureRequestID(w http.ResponseWriter, req *http.Request) {
+	if !l.options.EnableRequestID {
+		return
+	}
+	id := req.Header.Get(l.options.RequestIDHeader)
+	if len(id) == 0 {
+		id = string(uuid.NewUUID())
+		req.Header.Set(l.options.RequestIDHeader, id)
+	}
+	w.Header().Set(l.options.RequestIDHeader, id)
+}
+
+// requestID returns the correlation ID assigned to the request, or an empty
+// string when request IDs are disabled.
+func (l *Login) requestID(req *http.Request) string {
+	if !l.options.EnableRequestID {
+		return ""
+	}
+	return req.Header.Get(l.options.RequestIDHeader)
+}
+
+// requestLogSuffix returns a suffix tying a glog line to the request's
+// correlation ID, or an empty string when request IDs are disabled.
+func (l *Login) requestLogSuffix(req *http.Request) string {
+	if id := l.requestID(req); len(id) > 0 {
+		return fmt.Sprintf(" (request ID %s)", id)
+	}
+	return ""
+}
+
+// sourceIP returns the client IP for rate limiting and audit purposes.
+func (l *Login) sourceIP(req *http.Request) string {
+	return l.options.SourceIPExtractor.SourceIP(req)
+}
+
+// audit reports a login attempt outcome to the configured auditor, if any.
+func (l *Login) audit(username string, req *http.Request, success bool, reason string) {
+	l.logEvent("login_attempt", username, reason, success, req)
+	if l.options.Auditor == nil {
+		return
+	}
+	l.options.Auditor.RecordAttempt(username, l.sourceIP(req), success, reason)
+}
+
+// logEvent reports a structured event to the configured login logger, if any.
+func (l *Login) logEvent(event, username, reason string, success bool, req *http.Request) {
+	if l.options.Logger == nil {
+		return
+	}
+	l.options.Logger.LogLoginEvent(LoginEvent{
+		Event:     event,
+		Username:  username,
+		Reason:    reason,
+		Success:   success,
+		ClientIP:  l.sourceIP(req),
+		RequestID: l.requestID(req),
+	})
+}
+
+func (l *Login) usernameAutocomplete() string {
+	if len(l.options.UsernameAutocomplete) > 0 {
+		return l.options.UsernameAutocomplete
+	}
+	return defaultUsernameAutocomplete
+}
+
+func (l *Login) passwordAutocomplete() string {
+	if len(l.options.PasswordAutocomplete) > 0 {
+		return l.options.PasswordAutocomplete
+	}
+	return defaultPasswordAutocomplete
+}
+
+func (l *Login) maxRequestBodySize() int64 {
+	if l.options.MaxRequestBodySize > 0 {
+		return l.options.MaxRequestBodySize
+	}
+	return defaultMaxRequestBodySize
+}
+
 func (l *Login) handleLogin(w http.ResponseWriter, req *http.Request) {
-	if ok := l.csrf.Check(req, req.FormValue(csrfParam)); !ok {
+	if err := req.ParseForm(); err != nil {
+		// MaxBytesReader produces this error when the body exceeds the limit
+		if strings.Contains(err.Error(), "request body too large") {
+			http.Error(w, "Login request too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Could not parse form", http.StatusBadRequest)
+		}
+		return
+	}
+	if err := csrf.CheckError(l.csrf, req, req.FormValue(csrfParam)); err != nil {
 		glog.V(4).Infof("Invalid CSRF token: %s", req.FormValue(csrfParam))
-		failed(errorCodeTokenExpired, w, req)
+		// Only implementations that can positively identify tampering report
+		// ErrInvalid; everything else keeps the historical expiry reason.
+		if err == csrf.ErrInvalid {
+			failed(errorCodeTokenInvalid, w, req)
+		} else {
+			failed(errorCodeTokenExpired, w, req)
+		}
 		return
 	}
 	then := req.FormValue(thenParam)
+	if len(then) == 0 {
+		then = l.options.DefaultThen
+	}
 	if !redirect.IsServerRelativeURL(then) {
 		http.Redirect(w, req, "/", http.StatusFound)
 		return
 	}
 	username, password := req.FormValue(usernameParam), req.FormValue(passwordParam)
+	if l.options.RateLimiter != nil {
+		key := l.sourceIP(req)
+		if l.options.RateLimitByUsername {
+			key = key + "/" + username
+		}
+		if !l.options.RateLimiter.Allow(key) {
+			glog.V(4).Infof(`Login with provider %q throttled for %q%s`, l.provider, key, l.requestLogSuffix(req))
+			l.audit(username, req, false, errorCodeThrottled)
+			l.renderThrottled(w, req)
+			return
+		}
+	}
 	if len(username) == 0 {
+		l.audit(username, req, false, errorCodeUserRequired)
 		failed(errorCodeUserRequired, w, req)
 		return
 	}
 	if len(password) == 0 {
+		l.audit(username, req, false, errorCodeAccessDenied)
 		failed(errorCodeAccessDenied, w, req)
 		return
 	}
+	if l.options.Lockout != nil && l.options.Lockout.IsLockedOut(username) {
+		glog.V(4).Infof(`Login with provider %q refused for locked account %q from %s%s`, l.provider, username, l.sourceIP(req), l.requestLogSuffix(req))
+		l.audit(username, req, false, errorCodeAccountLocked)
+		failed(errorCodeAccountLocked, w, req)
+		return
+	}
 	result := metrics.SuccessResult
 	defer func() {
 		metrics.RecordFormPasswordAuth(result)
 	}()
 	user, ok, err := l.auth.AuthenticatePassword(username, password)
 	if err != nil {
-		utilruntime.HandleError(fmt.Errorf(`Error authenticating %q with provider %q: %v`, username, l.provider, err))
-		failed(errorpage.AuthenticationErrorCode(err), w, req)
+		utilruntime.HandleError(fmt.Errorf(`Error authenticating %q with provider %q from %s%s: %v`, username, l.provider, l.sourceIP(req), l.requestLogSuffix(req), err))
 		result = metrics.ErrorResult
+		l.audit(username, req, false, errorpage.AuthenticationErrorCode(err))
+		if l.options.FailClosedOnError {
+			http.Error(w, "Authentication is temporarily unavailable. Please try again later.", http.StatusServiceUnavailable)
+			return
+		}
+		failed(errorpage.AuthenticationErrorCode(err), w, req)
 		return
 	}
 	if !ok {
-		glog.V(4).Infof(`Login with provider %q failed for %q`, l.provider, username)
+		glog.V(4).Infof(`Login with provider %q failed for %q from %s%s`, l.provider, username, l.sourceIP(req), l.requestLogSuffix(req))
+		if l.options.Lockout != nil {
+			l.options.Lockout.RecordFailure(username)
+		}
+		l.audit(username, req, false, errorCodeAccessDenied)
 		failed(errorCodeAccessDenied, w, req)
 		result = metrics.FailResult
 		return
 	}
 	glog.V(4).Infof(`Login with provider %q succeeded for %q: %#v`, l.provider, username, user)
-	l.auth.AuthenticationSucceeded(user, then, w, req)
+	if l.options.Lockout != nil {
+		l.options.Lockout.RecordSuccess(username)
+	}
+	l.audit(username, req, true, "")
+	l.updateRememberedUsername(w, req, username)
+	l.auth.AuthenticationSucceeded(user, l.appendPreservedParams(then, req), w, req)
 }
 
 // NewLoginFormRenderer creates a login form renderer that takes in an optional custom template to
 // allow branding of the login page. Uses the default if customLoginTemplateFile is not set.
 func NewLoginFormRenderer(customLoginTemplateFile string) (*loginTemplateRenderer, error) {
+	return NewLoginFormRendererWithFuncs(customLoginTemplateFile, nil)
+}
+
+// NewLoginFormRendererWithFuncs works like NewLoginFormRenderer, but installs the
+// given function map on the template before parsing so custom templates can use
+// them for branding logic. Callers must only register vetted, side-effect-free
+// functions; anything else turns the template into an execution vector. Use
+// ValidateLoginTemplateWithFuncs with the same funcs so validation matches runtime.
+func NewLoginFormRendererWithFuncs(customLoginTemplateFile string, funcs template.FuncMap) (*loginTemplateRenderer, error) {
 	r := &loginTemplateRenderer{}
 	if len(customLoginTemplateFile) > 0 {
-		customTemplate, err := template.ParseFiles(customLoginTemplateFile)
+		customTemplate := template.New(filepath.Base(customLoginTemplateFile))
+		if funcs != nil {
+			customTemplate = customTemplate.Funcs(funcs)
+		}
+		customTemplate, err := customTemplate.ParseFiles(customLoginTemplateFile)
 		if err != nil {
 			return nil, err
 		}
@@ -208,27 +932,46 @@ func NewLoginFormRenderer(customLoginTemplateFile string) (*loginTemplateRendere
 }
 
 func ValidateLoginTemplate(templateContent []byte) []error {
+	return ValidateLoginTemplateWithFuncs(templateContent, nil)
+}
+
+// ValidateLoginTemplateWithFuncs validates the template with the given function
+// map registered, so templates using custom funcs can still be checked.
+func ValidateLoginTemplateWithFuncs(templateContent []byte, funcs template.FuncMap) []error {
 	var allErrs []error
 
-	template, err := template.New("loginTemplateTest").Parse(string(templateContent))
+	template := template.New("loginTemplateTest")
+	if funcs != nil {
+		template = template.Funcs(funcs)
+	}
+	template, err := template.Parse(string(templateContent))
 	if err != nil {
 		return append(allErrs, err)
 	}
 
 	// Execute the template with dummy values and check if they're there.
+	// ProviderName, LogoURL, the autocomplete hints and the remember-username
+	// fields are optional, so they are populated for templates that reference
+	// them but are not required to appear.
 	form := LoginForm{
-		Action: "MyAction",
-		Error:  "MyError",
+		ProviderName:         "MyProviderName",
+		LogoURL:              "MyLogoURL",
+		Action:               "MyAction",
+		Error:                "MyError",
+		UsernameAutocomplete: "MyUsernameAutocomplete",
+		PasswordAutocomplete: "MyPasswordAutocomplete",
 		Names: LoginFormFields{
 			Then:     "MyThenName",
 			CSRF:     "MyCSRFName",
 			Username: "MyUsernameName",
 			Password: "MyPasswordName",
+			Remember: "MyRememberName",
 		},
 		Values: LoginFormFields{
 			Then:     "MyThenValue",
 			CSRF:     "MyCSRFValue",
 			Username: "MyUsernameValue",
+			Remember: "MyRememberValue",
 		},
 	}
 
@@ -260,14 +1003,99 @@ func ValidateLoginTemplate(templateContent []byte) []error {
 	return allErrs
 }
 
+// NewLocalizedLoginFormRenderer creates a renderer that picks a template based
+// on the request's Accept-Language header. localizedTemplateFiles maps
+// language tags (e.g. "de", "pt-br") to template files; requests matching no
+// provided language use customLoginTemplateFile, or the built-in default when
+// that is empty. Every provided template is validated at construction.
+func NewLocalizedLoginFormRenderer(customLoginTemplateFile string, localizedTemplateFiles map[string]string) (*loginTemplateRenderer, error) {
+	r, err := NewLoginFormRenderer(customLoginTemplateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var allErrs []error
+	r.localizedTemplates = make(map[string]*template.Template, len(localizedTemplateFiles))
+	for lang, templateFile := range localizedTemplateFiles {
+		templateContent, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			allErrs = append(allErrs, err)
+			continue
+		}
+		if errs := ValidateLoginTemplate(templateContent); len(errs) > 0 {
+			for _, err := range errs {
+				allErrs = append(allErrs, fmt.Errorf("template %q for language %q is invalid: %v", templateFile, lang, err))
+			}
+			continue
+		}
+		localizedTemplate, err := template.ParseFiles(templateFile)
+		if err != nil {
+			allErrs = append(allErrs, err)
+			continue
+		}
+		r.localizedTemplates[strings.ToLower(lang)] = localizedTemplate
+	}
+	if len(allErrs) > 0 {
+		return nil, kerrors.NewAggregate(allErrs)
+	}
+
+	return r, nil
+}
+
 type loginTemplateRenderer struct {
 	loginTemplate *template.Template
+
+	// language tag -> template, consulted before loginTemplate
+	localizedTemplates map[string]*template.Template
+}
+
+// matchLanguage returns the first language in the Accept-Language header for
+// which a localized template exists, matching exact tags first and then
+// primary subtags (so "de-AT" can use a "de" template).
+func (r loginTemplateRenderer) matchLanguage(acceptLanguage string) (string, *template.Template) {
+	if len(r.localizedTemplates) == 0 {
+		return "", nil
+	}
+	for _, entry := range strings.Split(acceptLanguage, ",") {
+		lang := strings.TrimSpace(entry)
+		if i := strings.Index(lang, ";"); i >= 0 {
+			lang = strings.TrimSpace(lang[:i])
+		}
+		lang = strings.ToLower(lang)
+		if len(lang) == 0 || lang == "*" {
+			continue
+		}
+		if localizedTemplate, ok := r.localizedTemplates[lang]; ok {
+			return lang, localizedTemplate
+		}
+		if i := strings.Index(lang, "-"); i > 0 {
+			if localizedTemplate, ok := r.localizedTemplates[lang[:i]]; ok {
+				return lang[:i], localizedTemplate
+			}
+		}
+	}
+	return "", nil
 }
 
 func (r loginTemplateRenderer) Render(form LoginForm, w http.ResponseWriter, req *http.Request) {
+	loginTemplate := r.loginTemplate
+	if lang, localizedTemplate := r.matchLanguage(req.Header.Get("Accept-Language")); localizedTemplate != nil {
+		form.Lang = lang
+		loginTemplate = localizedTemplate
+	}
+
+	// Execute into a buffer first, so a template failure can still be
+	// reported as a 500 instead of a 200 with a truncated body.
+	var buffer bytes.Buffer
+	if err := loginTemplate.Execute(&buffer, form); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to render login template: %v", err))
+		http.Error(w, "Unable to render login page", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Add("Content-Type", "text/html; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
-	if err := r.loginTemplate.Execute(w, form); err != nil {
-		utilruntime.HandleError(fmt.Errorf("unable to render login template: %v", err))
+	if _, err := w.Write(buffer.Bytes()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write login template: %v", err))
 	}
 }
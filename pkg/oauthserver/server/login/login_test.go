@@ -1,11 +1,14 @@
 package login
 
 import (
+	"encoding/json"
 	"errors"
+	"html/template"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 
@@ -256,6 +259,1307 @@ func TestLogin(t *testing.T) {
 	}
 }
 
+func TestUnknownReasonPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		Policy           UnknownReasonPolicy
+		Path             string
+		ExpectContains   []string
+		ExpectNotContain []string
+	}{
+		"generic by default": {
+			Path:             "/login?then=%2F&reason=some_internal_reason",
+			ExpectContains:   []string{`An authentication error occurred.`},
+			ExpectNotContain: []string{`some_internal_reason`},
+		},
+		"generic policy": {
+			Policy:           UnknownReasonGeneric,
+			Path:             "/login?then=%2F&reason=some_internal_reason",
+			ExpectContains:   []string{`An authentication error occurred.`},
+			ExpectNotContain: []string{`some_internal_reason`},
+		},
+		"suppress policy omits banner": {
+			Policy:           UnknownReasonSuppress,
+			Path:             "/login?then=%2F&reason=some_internal_reason",
+			ExpectNotContain: []string{`An authentication error occurred.`, `some_internal_reason`, `danger`},
+		},
+		"suppress policy keeps known reasons": {
+			Policy:         UnknownReasonSuppress,
+			Path:           "/login?then=%2F&reason=access_denied",
+			ExpectContains: []string{`Invalid login or password. Please try again.`},
+		},
+		"echo policy shows sanitized reason": {
+			Policy:           UnknownReasonEcho,
+			Path:             "/login?then=%2F&reason=some%09_internal_%3Creason%3E",
+			ExpectContains:   []string{`some_internal_&lt;reason&gt;`},
+			ExpectNotContain: []string{`<reason>`},
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer, LoginOptions{UnknownReasonPolicy: testCase.Policy})
+		server := httptest.NewServer(login)
+
+		resp, err := getURL(server.URL + testCase.Path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		defer resp.Body.Close()
+
+		data, _ := ioutil.ReadAll(resp.Body)
+		body := string(data)
+		for _, expected := range testCase.ExpectContains {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: did not find expected value %s", k, expected)
+			}
+		}
+		for _, unexpected := range testCase.ExpectNotContain {
+			if strings.Contains(body, unexpected) {
+				t.Errorf("%s: found unexpected value %s", k, unexpected)
+			}
+		}
+	}
+}
+
+// explainingFakeCSRF reports a fixed typed error from checks.
+type explainingFakeCSRF struct {
+	token string
+	err   error
+}
+
+func (f *explainingFakeCSRF) Generate(w http.ResponseWriter, req *http.Request) string {
+	return f.token
+}
+
+func (f *explainingFakeCSRF) Check(req *http.Request, value string) bool {
+	return f.err == nil
+}
+
+func (f *explainingFakeCSRF) CheckError(req *http.Request, value string) error {
+	return f.err
+}
+
+func TestLoginCSRFReasonCodes(t *testing.T) {
+	testCases := map[string]struct {
+		Err            error
+		ExpectRedirect string
+	}{
+		"expired token": {
+			Err:            csrf.ErrExpired,
+			ExpectRedirect: "/login?reason=token_expired&then=%2Fdone",
+		},
+		"invalid token": {
+			Err:            csrf.ErrInvalid,
+			ExpectRedirect: "/login?reason=token_invalid&then=%2Fdone",
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		login := NewLogin("myprovider", &explainingFakeCSRF{token: "test", err: testCase.Err}, &testAuth{}, loginFormRenderer)
+		server := httptest.NewServer(login)
+
+		resp, err := postForm(server.URL+"/login", url.Values{
+			"csrf":     []string{"test"},
+			"username": []string{"user"},
+			"password": []string{"pass"},
+			"then":     []string{"/done"},
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		resp.Body.Close()
+
+		uri, err := resp.Location()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		if uri.String() != server.URL+testCase.ExpectRedirect {
+			t.Errorf("%s: unexpected redirect: %s", k, uri.String())
+		}
+	}
+}
+
+func TestLoginReasonMessages(t *testing.T) {
+	testCases := map[string]struct {
+		ReasonMessages   map[string]string
+		Path             string
+		ExpectContains   []string
+		ExpectNotContain []string
+	}{
+		"custom reason": {
+			ReasonMessages: map[string]string{"password_expired": "Your password has expired."},
+			Path:           "/login?then=%2F&reason=password_expired",
+			ExpectContains: []string{`Your password has expired.`},
+		},
+		"custom reason overrides default": {
+			ReasonMessages:   map[string]string{"access_denied": "Check your credentials with your administrator."},
+			Path:             "/login?then=%2F&reason=access_denied",
+			ExpectContains:   []string{`Check your credentials with your administrator.`},
+			ExpectNotContain: []string{`Invalid login or password.`},
+		},
+		"defaults still apply with nil map": {
+			Path:           "/login?then=%2F&reason=access_denied",
+			ExpectContains: []string{`Invalid login or password. Please try again.`},
+		},
+		"unknown reason still generic": {
+			ReasonMessages:   map[string]string{"password_expired": "Your password has expired."},
+			Path:             "/login?then=%2F&reason=other_reason",
+			ExpectContains:   []string{`An authentication error occurred.`},
+			ExpectNotContain: []string{`other_reason`},
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer, LoginOptions{ReasonMessages: testCase.ReasonMessages})
+		server := httptest.NewServer(login)
+
+		resp, err := getURL(server.URL + testCase.Path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		defer resp.Body.Close()
+
+		data, _ := ioutil.ReadAll(resp.Body)
+		body := string(data)
+		for _, expected := range testCase.ExpectContains {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: did not find expected value %s", k, expected)
+			}
+		}
+		for _, unexpected := range testCase.ExpectNotContain {
+			if strings.Contains(body, unexpected) {
+				t.Errorf("%s: found unexpected value %s", k, unexpected)
+			}
+		}
+	}
+}
+
+func TestLoginFailClosed(t *testing.T) {
+	loginFormRenderer, err := NewLoginFormRenderer("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{Err: errors.New("backend down")}, loginFormRenderer, LoginOptions{FailClosedOnError: true})
+	server := httptest.NewServer(login)
+
+	resp, err := postForm(server.URL+"/login", url.Values{
+		"csrf":     []string{"test"},
+		"username": []string{"user"},
+		"password": []string{"pass"},
+		"then":     []string{"/anotherurl"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if strings.Contains(string(data), "pass") {
+		t.Errorf("response must not contain the password: %s", string(data))
+	}
+}
+
+// countingCSRF wraps FakeCSRF and counts Generate calls.
+type countingCSRF struct {
+	csrf.FakeCSRF
+	generated int
+}
+
+func (c *countingCSRF) Generate(w http.ResponseWriter, req *http.Request) string {
+	c.generated++
+	return c.FakeCSRF.Generate(w, req)
+}
+
+func TestInstallHealth(t *testing.T) {
+	loginFormRenderer, err := NewLoginFormRenderer("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	countingCSRF := &countingCSRF{FakeCSRF: csrf.FakeCSRF{Token: "test"}}
+	login := NewLogin("myprovider", countingCSRF, &testAuth{}, loginFormRenderer)
+	mux := http.NewServeMux()
+	login.Install(mux, "/login")
+	login.InstallHealth(mux, "/healthz")
+	server := httptest.NewServer(mux)
+
+	// GET returns a tiny JSON body without touching CSRF state
+	resp, err := getURL(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if string(data) != `{"status":"ok"}` {
+		t.Errorf("unexpected body: %s", string(data))
+	}
+	if countingCSRF.generated != 0 {
+		t.Errorf("health endpoint must not generate CSRF tokens")
+	}
+
+	// Non-GET is rejected
+	resp, err = postForm(server.URL+"/healthz", url.Values{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	// The login path still renders the form
+	resp, err = getURL(server.URL + "/login?then=%2F")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), `action="/login"`) {
+		t.Errorf("login form no longer renders: %s", string(data))
+	}
+	if countingCSRF.generated != 1 {
+		t.Errorf("expected one CSRF token for the login form, got %d", countingCSRF.generated)
+	}
+}
+
+type recordedAttempt struct {
+	Username string
+	ClientIP string
+	Success  bool
+	Reason   string
+}
+
+type recordingAuditor struct {
+	attempts []recordedAttempt
+}
+
+func (a *recordingAuditor) RecordAttempt(username, clientIP string, success bool, reason string) {
+	a.attempts = append(a.attempts, recordedAttempt{Username: username, ClientIP: clientIP, Success: success, Reason: reason})
+}
+
+func TestLoginAuditor(t *testing.T) {
+	testCases := map[string]struct {
+		Auth       *testAuth
+		PostValues url.Values
+
+		ExpectAttempt *recordedAttempt
+	}{
+		"failed CSRF is not audited": {
+			Auth:       &testAuth{},
+			PostValues: url.Values{"csrf": []string{"wrong"}, "then": []string{"/done"}},
+		},
+		"missing username": {
+			Auth: &testAuth{},
+			PostValues: url.Values{
+				"csrf": []string{"test"},
+				"then": []string{"/done"},
+			},
+			ExpectAttempt: &recordedAttempt{Username: "", ClientIP: "127.0.0.1", Success: false, Reason: "user_required"},
+		},
+		"missing password": {
+			Auth: &testAuth{},
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"then":     []string{"/done"},
+			},
+			ExpectAttempt: &recordedAttempt{Username: "user", ClientIP: "127.0.0.1", Success: false, Reason: "access_denied"},
+		},
+		"denied": {
+			Auth: &testAuth{Success: false},
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/done"},
+			},
+			ExpectAttempt: &recordedAttempt{Username: "user", ClientIP: "127.0.0.1", Success: false, Reason: "access_denied"},
+		},
+		"authenticator error": {
+			Auth: &testAuth{Err: errors.New("backend down")},
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/done"},
+			},
+			ExpectAttempt: &recordedAttempt{Username: "user", ClientIP: "127.0.0.1", Success: false, Reason: "authentication_error"},
+		},
+		"success": {
+			Auth: &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}},
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/done"},
+			},
+			ExpectAttempt: &recordedAttempt{Username: "user", ClientIP: "127.0.0.1", Success: true, Reason: ""},
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		auditor := &recordingAuditor{}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, testCase.Auth, loginFormRenderer, LoginOptions{Auditor: auditor})
+		server := httptest.NewServer(login)
+
+		resp, err := postForm(server.URL+"/login", testCase.PostValues)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if testCase.ExpectAttempt == nil {
+			if len(auditor.attempts) != 0 {
+				t.Errorf("%s: unexpected audit attempts: %#v", k, auditor.attempts)
+			}
+			continue
+		}
+		if len(auditor.attempts) != 1 {
+			t.Errorf("%s: expected one audit attempt, got %#v", k, auditor.attempts)
+			continue
+		}
+		if auditor.attempts[0] != *testCase.ExpectAttempt {
+			t.Errorf("%s: expected attempt %#v, got %#v", k, *testCase.ExpectAttempt, auditor.attempts[0])
+		}
+	}
+}
+
+type recordingLoginLogger struct {
+	events []LoginEvent
+}
+
+func (l *recordingLoginLogger) LogLoginEvent(event LoginEvent) {
+	l.events = append(l.events, event)
+}
+
+func TestLoginLogger(t *testing.T) {
+	testCases := map[string]struct {
+		Auth       *testAuth
+		Path       string
+		PostValues url.Values
+
+		ExpectEvent LoginEvent
+	}{
+		"form render": {
+			Auth:        &testAuth{},
+			Path:        "/login?then=%2F&reason=access_denied",
+			ExpectEvent: LoginEvent{Event: "form_render", Reason: "access_denied", ClientIP: "127.0.0.1"},
+		},
+		"missing username": {
+			Auth: &testAuth{},
+			Path: "/login",
+			PostValues: url.Values{
+				"csrf": []string{"test"},
+				"then": []string{"/done"},
+			},
+			ExpectEvent: LoginEvent{Event: "login_attempt", Reason: "user_required", ClientIP: "127.0.0.1"},
+		},
+		"denied": {
+			Auth: &testAuth{Success: false},
+			Path: "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/done"},
+			},
+			ExpectEvent: LoginEvent{Event: "login_attempt", Username: "user", Reason: "access_denied", ClientIP: "127.0.0.1"},
+		},
+		"success": {
+			Auth: &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}},
+			Path: "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/done"},
+			},
+			ExpectEvent: LoginEvent{Event: "login_attempt", Username: "user", Success: true, ClientIP: "127.0.0.1"},
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		logger := &recordingLoginLogger{}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, testCase.Auth, loginFormRenderer, LoginOptions{Logger: logger})
+		server := httptest.NewServer(login)
+
+		var resp *http.Response
+		if testCase.PostValues != nil {
+			resp, err = postForm(server.URL+testCase.Path, testCase.PostValues)
+		} else {
+			resp, err = getURL(server.URL + testCase.Path)
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if len(logger.events) != 1 {
+			t.Errorf("%s: expected one event, got %#v", k, logger.events)
+			continue
+		}
+		if logger.events[0] != testCase.ExpectEvent {
+			t.Errorf("%s: expected event %#v, got %#v", k, testCase.ExpectEvent, logger.events[0])
+		}
+	}
+}
+
+func TestLoginRequestID(t *testing.T) {
+	testCases := map[string]struct {
+		Header      string
+		IncomingID  string
+		ExpectEcho  bool
+		ExpectIDGen bool
+	}{
+		"preserved when provided": {
+			Header:     "X-Request-Id",
+			IncomingID: "abc-123",
+			ExpectEcho: true,
+		},
+		"generated when absent": {
+			Header:      "X-Request-Id",
+			ExpectIDGen: true,
+		},
+		"custom header preserved": {
+			Header:     "X-Correlation-Id",
+			IncomingID: "trace-1",
+			ExpectEcho: true,
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		logger := &recordingLoginLogger{}
+		options := LoginOptions{EnableRequestID: true, Logger: logger}
+		if testCase.Header != defaultRequestIDHeader {
+			options.RequestIDHeader = testCase.Header
+		}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer, options)
+		server := httptest.NewServer(login)
+
+		req, err := http.NewRequest("GET", server.URL+"/login?then=%2F", nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		if len(testCase.IncomingID) > 0 {
+			req.Header.Set(testCase.Header, testCase.IncomingID)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		resp.Body.Close()
+
+		echoed := resp.Header.Get(testCase.Header)
+		if testCase.ExpectEcho && echoed != testCase.IncomingID {
+			t.Errorf("%s: expected response header %s=%q, got %q", k, testCase.Header, testCase.IncomingID, echoed)
+		}
+		if testCase.ExpectIDGen && len(echoed) == 0 {
+			t.Errorf("%s: expected a generated ID in response header %s, got none", k, testCase.Header)
+		}
+		if len(logger.events) != 1 {
+			t.Errorf("%s: expected one event, got %#v", k, logger.events)
+			continue
+		}
+		if logger.events[0].RequestID != echoed {
+			t.Errorf("%s: expected event request ID %q, got %q", k, echoed, logger.events[0].RequestID)
+		}
+	}
+}
+
+func TestTokenCheck(t *testing.T) {
+	loginFormRenderer, err := NewLoginFormRenderer("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	login := NewLogin("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer)
+	mux := http.NewServeMux()
+	login.InstallTokenCheck(mux, "/login/csrf")
+	server := httptest.NewServer(mux)
+
+	testCases := map[string]struct {
+		Path             string
+		Header           http.Header
+		ExpectStatusCode int
+	}{
+		"valid token via query": {
+			Path:             "/login/csrf?csrf=test",
+			ExpectStatusCode: 200,
+		},
+		"valid token via header": {
+			Path:             "/login/csrf",
+			Header:           http.Header{"X-Csrf-Token": []string{"test"}},
+			ExpectStatusCode: 200,
+		},
+		"invalid token": {
+			Path:             "/login/csrf?csrf=wrong",
+			ExpectStatusCode: 401,
+		},
+		"missing token": {
+			Path:             "/login/csrf",
+			ExpectStatusCode: 401,
+		},
+	}
+
+	for k, testCase := range testCases {
+		req, err := http.NewRequest("GET", server.URL+testCase.Path, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		for key, values := range testCase.Header {
+			req.Header[key] = values
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != testCase.ExpectStatusCode {
+			t.Errorf("%s: expected status %d, got %d", k, testCase.ExpectStatusCode, resp.StatusCode)
+		}
+	}
+}
+
+func TestLoginRequestBodyLimit(t *testing.T) {
+	loginFormRenderer, err := NewLoginFormRenderer("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auth := &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}}
+	login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, auth, loginFormRenderer, LoginOptions{MaxRequestBodySize: 1024})
+	server := httptest.NewServer(login)
+
+	// An oversized body must be rejected before any form value is read
+	resp, err := postForm(server.URL+"/login", url.Values{
+		"csrf":     []string{"test"},
+		"username": []string{"user"},
+		"password": []string{strings.Repeat("x", 2048)},
+		"then":     []string{"/done"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+	if auth.Called {
+		t.Errorf("authenticator must not be called for an oversized request")
+	}
+
+	// A normally-sized form must still log in
+	resp, err = postForm(server.URL+"/login", url.Values{
+		"csrf":     []string{"test"},
+		"username": []string{"user"},
+		"password": []string{"pass"},
+		"then":     []string{"/done"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if !auth.Called || auth.Then != "/done" {
+		t.Errorf("did not find expected 'then' value: %#v", auth)
+	}
+}
+
+func TestLoginPreservedQueryParams(t *testing.T) {
+	const extraTemplate = `{{ .Error }} {{ .Action }} {{ .Names.Then }} {{ .Values.Then }} {{ .Names.CSRF }} {{ .Values.CSRF }} {{ .Names.Username }} {{ .Values.Username }} {{ .Names.Password }}{{ range $name, $value := .Extra }} <input type="hidden" name="{{ $name }}" value="{{ $value }}">{{ end }}`
+
+	// Templates ranging over the extra fields must validate
+	for _, err := range ValidateLoginTemplate([]byte(extraTemplate)) {
+		t.Errorf("template validation failed when it should have succeeded: %v", err)
+	}
+
+	templateFile, err := ioutil.TempFile("", "logintemplate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(templateFile.Name())
+	if _, err := templateFile.WriteString(extraTemplate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templateFile.Close()
+
+	loginFormRenderer, err := NewLoginFormRenderer(templateFile.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auth := &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}}
+	login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, auth, loginFormRenderer, LoginOptions{
+		PreservedQueryParams: []string{"client_id", "state"},
+	})
+	server := httptest.NewServer(login)
+
+	// Allowlisted params show up as hidden fields; others are dropped
+	resp, err := getURL(server.URL + "/login?then=%2F&client_id=myclient&other=dropped")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	body := string(data)
+	if !strings.Contains(body, `name="client_id" value="myclient"`) {
+		t.Errorf("did not find expected hidden field in form: %s", body)
+	}
+	if strings.Contains(body, "dropped") {
+		t.Errorf("found non-allowlisted param in form: %s", body)
+	}
+
+	// Allowlisted params submitted with the POST are forwarded via the then URL
+	resp, err = postForm(server.URL+"/login", url.Values{
+		"csrf":      []string{"test"},
+		"username":  []string{"user"},
+		"password":  []string{"pass"},
+		"then":      []string{"/done"},
+		"client_id": []string{"myclient"},
+		"other":     []string{"dropped"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if !auth.Called || auth.Then != "/done?client_id=myclient" {
+		t.Errorf("did not find expected 'then' value: %#v", auth)
+	}
+}
+
+func TestLoginDefaultThen(t *testing.T) {
+	testCases := map[string]struct {
+		DefaultThen string
+		Path        string
+		PostValues  url.Values
+
+		ExpectRedirect string
+		ExpectThen     string
+		ExpectContains []string
+	}{
+		"default used when POST has no then": {
+			DefaultThen: "/console",
+			Path:        "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+			},
+			ExpectThen: "/console",
+		},
+		"submitted then wins over default": {
+			DefaultThen: "/console",
+			Path:        "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+				"then":     []string{"/done"},
+			},
+			ExpectThen: "/done",
+		},
+		"default used when GET has no then": {
+			DefaultThen: "/console",
+			Path:        "/login",
+			ExpectContains: []string{
+				`name="then" value="/console"`,
+			},
+		},
+		"non-relative default is ignored at construction": {
+			DefaultThen: "https://attacker.example.com/",
+			Path:        "/login",
+			PostValues: url.Values{
+				"csrf":     []string{"test"},
+				"username": []string{"user"},
+				"password": []string{"pass"},
+			},
+			ExpectRedirect: "/",
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		auth := &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, auth, loginFormRenderer, LoginOptions{DefaultThen: testCase.DefaultThen})
+		server := httptest.NewServer(login)
+
+		var resp *http.Response
+		if testCase.PostValues != nil {
+			resp, err = postForm(server.URL+testCase.Path, testCase.PostValues)
+		} else {
+			resp, err = getURL(server.URL + testCase.Path)
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		defer resp.Body.Close()
+
+		if testCase.ExpectRedirect != "" {
+			uri, err := resp.Location()
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", k, err)
+				continue
+			}
+			if uri.String() != server.URL+testCase.ExpectRedirect {
+				t.Errorf("%s: unexpected redirect: %s", k, uri.String())
+			}
+			if auth.Called {
+				t.Errorf("%s: authenticator must not be called", k)
+			}
+		}
+
+		if testCase.ExpectThen != "" && (!auth.Called || auth.Then != testCase.ExpectThen) {
+			t.Errorf("%s: did not find expected 'then' value: %#v", k, auth)
+		}
+
+		if len(testCase.ExpectContains) > 0 {
+			data, _ := ioutil.ReadAll(resp.Body)
+			body := string(data)
+			for _, expected := range testCase.ExpectContains {
+				if !strings.Contains(body, expected) {
+					t.Errorf("%s: did not find expected value %s", k, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestLoginBranding(t *testing.T) {
+	const brandedTemplate = `{{ .ProviderName }} {{ .LogoURL }} {{ .Error }} {{ .Action }} {{ .Names.Then }} {{ .Values.Then }} {{ .Names.CSRF }} {{ .Values.CSRF }} {{ .Names.Username }} {{ .Values.Username }} {{ .Names.Password }}`
+
+	// Templates referencing the branding fields must validate
+	for _, err := range ValidateLoginTemplate([]byte(brandedTemplate)) {
+		t.Errorf("template validation failed when it should have succeeded: %v", err)
+	}
+
+	templateFile, err := ioutil.TempFile("", "logintemplate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(templateFile.Name())
+	if _, err := templateFile.WriteString(brandedTemplate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templateFile.Close()
+
+	loginFormRenderer, err := NewLoginFormRenderer(templateFile.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer, LoginOptions{LogoURL: "https://example.com/logo.png"})
+	server := httptest.NewServer(login)
+
+	resp, err := getURL(server.URL + "/login?then=%2F")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	body := string(data)
+	for _, expected := range []string{"myprovider", "https://example.com/logo.png"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("did not find expected value %s in rendered template: %s", expected, body)
+		}
+	}
+}
+
+func TestLoginAutocomplete(t *testing.T) {
+	const autocompleteTemplate = `{{ .UsernameAutocomplete }} {{ .PasswordAutocomplete }} {{ .Error }} {{ .Action }} {{ .Names.Then }} {{ .Values.Then }} {{ .Names.CSRF }} {{ .Values.CSRF }} {{ .Names.Username }} {{ .Values.Username }} {{ .Names.Password }}`
+
+	// Templates referencing the autocomplete fields must validate
+	for _, err := range ValidateLoginTemplate([]byte(autocompleteTemplate)) {
+		t.Errorf("template validation failed when it should have succeeded: %v", err)
+	}
+
+	templateFile, err := ioutil.TempFile("", "logintemplate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(templateFile.Name())
+	if _, err := templateFile.WriteString(autocompleteTemplate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templateFile.Close()
+
+	testCases := map[string]struct {
+		Options        LoginOptions
+		ExpectContains []string
+	}{
+		"defaults": {
+			ExpectContains: []string{"username", "current-password"},
+		},
+		"autofill disabled": {
+			Options:        LoginOptions{UsernameAutocomplete: "off", PasswordAutocomplete: "off"},
+			ExpectContains: []string{"off off"},
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer(templateFile.Name())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer, testCase.Options)
+		server := httptest.NewServer(login)
+
+		resp, err := getURL(server.URL + "/login?then=%2F")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		body := string(data)
+		for _, expected := range testCase.ExpectContains {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: did not find expected value %s in rendered template: %s", k, expected, body)
+			}
+		}
+	}
+}
+
+func TestRememberUsername(t *testing.T) {
+	newServer := func() (*testAuth, *httptest.Server) {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		auth := &testAuth{Success: true, User: &user.DefaultInfo{Name: "bob"}}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, auth, loginFormRenderer, LoginOptions{RememberUsername: &RememberUsernameCookie{}})
+		return auth, httptest.NewServer(login)
+	}
+
+	findCookie := func(resp *http.Response) *http.Cookie {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == "openshift_remembered_username" {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// A successful login with the remember checkbox set stores the username
+	_, server := newServer()
+	resp, err := postForm(server.URL+"/login", url.Values{
+		"csrf":     []string{"test"},
+		"username": []string{"bob"},
+		"password": []string{"secret"},
+		"then":     []string{"/done"},
+		"remember": []string{"on"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	cookie := findCookie(resp)
+	if cookie == nil {
+		t.Fatalf("expected remember-username cookie to be set, got %v", resp.Cookies())
+	}
+	if cookie.Value != "bob" {
+		t.Errorf("expected cookie to hold the username, got %q", cookie.Value)
+	}
+	if strings.Contains(cookie.Value, "secret") {
+		t.Errorf("cookie must never contain the password: %q", cookie.Value)
+	}
+	if !cookie.HttpOnly || cookie.MaxAge <= 0 {
+		t.Errorf("unexpected cookie attributes: %#v", cookie)
+	}
+
+	// The stored username pre-fills the form on render
+	_, server = newServer()
+	req, err := http.NewRequest("GET", server.URL+"/login?then=%2F", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.AddCookie(&http.Cookie{Name: "openshift_remembered_username", Value: "bob"})
+	resp, err = http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), `value="bob"`) {
+		t.Errorf("expected username to be pre-filled, got %s", data)
+	}
+
+	// A successful login without the checkbox clears the stored username
+	_, server = newServer()
+	resp, err = postForm(server.URL+"/login", url.Values{
+		"csrf":     []string{"test"},
+		"username": []string{"bob"},
+		"password": []string{"secret"},
+		"then":     []string{"/done"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	cookie = findCookie(resp)
+	if cookie == nil {
+		t.Fatalf("expected remember-username cookie to be cleared, got %v", resp.Cookies())
+	}
+	if len(cookie.Value) > 0 || cookie.MaxAge >= 0 {
+		t.Errorf("expected an expired empty cookie, got %#v", cookie)
+	}
+}
+
+func TestLoginSecurityHeaders(t *testing.T) {
+	testCases := map[string]struct {
+		Options       LoginOptions
+		ExpectHeaders map[string]string
+	}{
+		"defaults": {
+			ExpectHeaders: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Content-Security-Policy":   "default-src 'self'; style-src 'self' 'unsafe-inline'",
+				"Strict-Transport-Security": "",
+			},
+		},
+		"configured": {
+			Options: LoginOptions{
+				ContentSecurityPolicy:   "default-src 'self'; font-src fonts.example.com",
+				StrictTransportSecurity: "max-age=31536000",
+				FrameOptions:            "SAMEORIGIN",
+			},
+			ExpectHeaders: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "SAMEORIGIN",
+				"Content-Security-Policy":   "default-src 'self'; font-src fonts.example.com",
+				"Strict-Transport-Security": "max-age=31536000",
+			},
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer, testCase.Options)
+		server := httptest.NewServer(login)
+
+		resp, err := getURL(server.URL + "/login?then=%2F")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		resp.Body.Close()
+
+		for header, expected := range testCase.ExpectHeaders {
+			if actual := resp.Header.Get(header); actual != expected {
+				t.Errorf("%s: expected header %s=%q, got %q", k, header, expected, actual)
+			}
+		}
+	}
+}
+
+func TestLoginFormJSON(t *testing.T) {
+	loginFormRenderer, err := NewLoginFormRenderer("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	login := NewLogin("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer)
+	server := httptest.NewServer(login)
+
+	// HTML remains the default for browsers
+	req, err := http.NewRequest("GET", server.URL+"/login?then=%2F", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("expected HTML content type, got %q", contentType)
+	}
+
+	// JSON is served when explicitly requested
+	req, err = http.NewRequest("GET", server.URL+"/login?then=%2F&reason=access_denied", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err = http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected JSON content type, got %q", contentType)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	form := loginFormJSON{}
+	if err := json.Unmarshal(data, &form); err != nil {
+		t.Fatalf("unexpected error unmarshalling %s: %v", data, err)
+	}
+	if form.ProviderName != "myprovider" {
+		t.Errorf("expected provider name in JSON form, got %#v", form)
+	}
+	if form.ErrorCode != "access_denied" || len(form.Error) == 0 {
+		t.Errorf("expected error code and message in JSON form, got %#v", form)
+	}
+	if form.Names.Username != "username" || form.Names.Password != "password" {
+		t.Errorf("expected field names in JSON form, got %#v", form)
+	}
+	if form.Values.Then != "/" || form.Values.CSRF != "test" {
+		t.Errorf("expected then and CSRF values in JSON form, got %#v", form)
+	}
+	if len(form.Values.Password) > 0 {
+		t.Errorf("expected no password value in JSON form, got %#v", form)
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	testCases := map[string]struct {
+		Options      LoginOptions
+		ExpectStatus int
+	}{
+		"default status": {
+			ExpectStatus: http.StatusOK,
+		},
+		"configured status": {
+			Options:      LoginOptions{ErrorStatusCode: http.StatusBadGateway},
+			ExpectStatus: http.StatusBadGateway,
+		},
+	}
+
+	for k, testCase := range testCases {
+		loginFormRenderer, err := NewLoginFormRenderer("")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "csrf-sentinel"}, &testAuth{}, loginFormRenderer, testCase.Options)
+
+		req, err := http.NewRequest("GET", "http://www.example.com/errorpage", nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		recorder := httptest.NewRecorder()
+		login.RenderError(recorder, req, "your identity provider returned an error")
+
+		if recorder.Code != testCase.ExpectStatus {
+			t.Errorf("%s: expected status %d, got %d", k, testCase.ExpectStatus, recorder.Code)
+		}
+		body := recorder.Body.String()
+		if !strings.Contains(body, "your identity provider returned an error") {
+			t.Errorf("%s: did not find expected error message in rendered template: %s", k, body)
+		}
+		if strings.Contains(body, "csrf-sentinel") {
+			t.Errorf("%s: expected no CSRF token to be generated, found one in: %s", k, body)
+		}
+	}
+}
+
+func TestLoginRenderFailure(t *testing.T) {
+	// This template parses, but fails at execution time
+	templateFile, err := ioutil.TempFile("", "logintemplate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(templateFile.Name())
+	if _, err := templateFile.WriteString(`before {{ .DoesNotExist }} after`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templateFile.Close()
+
+	loginFormRenderer, err := NewLoginFormRenderer(templateFile.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	login := NewLogin("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer)
+	server := httptest.NewServer(login)
+
+	resp, err := getURL(server.URL + "/login?then=%2F")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if strings.Contains(string(data), "before") {
+		t.Errorf("response must not contain partial template output: %s", string(data))
+	}
+}
+
+func TestLoginTemplateFuncs(t *testing.T) {
+	const funcTemplate = `{{ .Error }} {{ .Action }} {{ .Names.Then }} {{ .Values.Then }} {{ .Names.CSRF }} {{ .Values.CSRF }} {{ .Names.Username }} {{ .Values.Username }} {{ .Names.Password }} {{ banner }}`
+	funcs := template.FuncMap{
+		"banner": func() string { return "maintenance window" },
+	}
+
+	// Validation without the funcs registered must fail
+	if allErrs := ValidateLoginTemplate([]byte(funcTemplate)); len(allErrs) == 0 {
+		t.Errorf("template validation succeeded when it should have failed")
+	}
+	// Validation with the funcs registered must succeed
+	for _, err := range ValidateLoginTemplateWithFuncs([]byte(funcTemplate), funcs) {
+		t.Errorf("template validation failed when it should have succeeded: %v", err)
+	}
+
+	// The renderer must register the same funcs on custom templates
+	templateFile, err := ioutil.TempFile("", "logintemplate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(templateFile.Name())
+	if _, err := templateFile.WriteString(funcTemplate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templateFile.Close()
+
+	loginFormRenderer, err := NewLoginFormRendererWithFuncs(templateFile.Name(), funcs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	login := NewLogin("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer)
+	server := httptest.NewServer(login)
+
+	resp, err := getURL(server.URL + "/login?then=%2F")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(data), "maintenance window") {
+		t.Errorf("did not find expected value in rendered template: %s", string(data))
+	}
+}
+
+func TestLocalizedLoginTemplates(t *testing.T) {
+	germanTemplate := strings.Replace(originalLoginTemplateExample, "Log In", "Anmelden", 1)
+	templateFile, err := ioutil.TempFile("", "logintemplate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(templateFile.Name())
+	if _, err := templateFile.WriteString(germanTemplate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templateFile.Close()
+
+	// Invalid localized templates must be rejected at construction
+	invalidFile, err := ioutil.TempFile("", "logintemplate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(invalidFile.Name())
+	if _, err := invalidFile.WriteString(invalidLoginTemplate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	invalidFile.Close()
+	if _, err := NewLocalizedLoginFormRenderer("", map[string]string{"de": invalidFile.Name()}); err == nil {
+		t.Errorf("expected error for invalid localized template")
+	}
+
+	loginFormRenderer, err := NewLocalizedLoginFormRenderer("", map[string]string{"de": templateFile.Name()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	login := NewLogin("myprovider", &csrf.FakeCSRF{Token: "test"}, &testAuth{}, loginFormRenderer)
+	server := httptest.NewServer(login)
+
+	testCases := map[string]struct {
+		AcceptLanguage string
+		ExpectContains string
+	}{
+		"no header falls back to default": {
+			AcceptLanguage: "",
+			ExpectContains: "Log In",
+		},
+		"unknown language falls back to default": {
+			AcceptLanguage: "fr",
+			ExpectContains: "Log In",
+		},
+		"exact match": {
+			AcceptLanguage: "de",
+			ExpectContains: "Anmelden",
+		},
+		"primary subtag match with q-values": {
+			AcceptLanguage: "de-AT;q=0.9, en;q=0.8",
+			ExpectContains: "Anmelden",
+		},
+	}
+
+	for k, testCase := range testCases {
+		req, err := http.NewRequest("GET", server.URL+"/login?then=%2F", nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		if len(testCase.AcceptLanguage) > 0 {
+			req.Header.Set("Accept-Language", testCase.AcceptLanguage)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(data), testCase.ExpectContains) {
+			t.Errorf("%s: did not find expected value %s", k, testCase.ExpectContains)
+		}
+	}
+}
+
 func TestValidateLoginTemplate(t *testing.T) {
 	testCases := map[string]struct {
 		Template      string
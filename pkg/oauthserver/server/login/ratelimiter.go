@@ -0,0 +1,83 @@
+package login
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginRateLimiter throttles login attempts. Allow reports whether the
+// attempt identified by key (typically the client IP, optionally combined
+// with the submitted username) may proceed.
+type LoginRateLimiter interface {
+	Allow(key string) bool
+}
+
+// tokenBucketRateLimiter is a simple per-key token bucket. Each key gets
+// burst tokens up front and refills at attemptsPerSecond.
+type tokenBucketRateLimiter struct {
+	lock sync.Mutex
+
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+
+	// for testing
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketRateLimiter returns a LoginRateLimiter allowing burst
+// attempts immediately per key, refilling at attemptsPerSecond.
+func NewTokenBucketRateLimiter(attemptsPerSecond float64, burst int) LoginRateLimiter {
+	return &tokenBucketRateLimiter{
+		rate:    attemptsPerSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (rl *tokenBucketRateLimiter) Allow(key string) bool {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	now := rl.now()
+	rl.evictIdle(now)
+	b := rl.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rl.rate
+		if b.tokens > rl.burst {
+			b.tokens = rl.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// evictIdle drops buckets that have sat idle long enough to refill
+// completely, which makes them indistinguishable from fresh ones. Keys are
+// attacker-controlled on an unauthenticated endpoint, so without eviction the
+// map grows without bound. Callers must hold the lock.
+func (rl *tokenBucketRateLimiter) evictIdle(now time.Time) {
+	if rl.rate <= 0 {
+		return
+	}
+	idle := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) > idle {
+			delete(rl.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package login
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+
+	"github.com/openshift/origin/pkg/oauthserver/server/csrf"
+)
+
+func TestTokenBucketRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := NewTokenBucketRateLimiter(1, 2).(*tokenBucketRateLimiter)
+	rl.now = func() time.Time { return now }
+
+	// The burst is available immediately, then attempts are refused
+	for i := 0; i < 2; i++ {
+		if !rl.Allow("a") {
+			t.Fatalf("attempt %d unexpectedly refused", i)
+		}
+	}
+	if rl.Allow("a") {
+		t.Fatalf("attempt unexpectedly allowed past burst")
+	}
+
+	// Other keys have their own budget
+	if !rl.Allow("b") {
+		t.Fatalf("attempt for other key unexpectedly refused")
+	}
+
+	// Tokens refill over time, capped at the burst
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		if !rl.Allow("a") {
+			t.Fatalf("attempt %d after refill unexpectedly refused", i)
+		}
+	}
+	if rl.Allow("a") {
+		t.Fatalf("attempt unexpectedly allowed past refilled burst")
+	}
+
+	// Buckets idle long enough to have refilled completely are evicted, so
+	// attacker-chosen keys cannot grow the map without bound
+	now = now.Add(10 * time.Second)
+	if !rl.Allow("c") {
+		t.Fatalf("attempt for new key unexpectedly refused")
+	}
+	if len(rl.buckets) != 1 {
+		t.Fatalf("expected idle buckets to be evicted, have %d", len(rl.buckets))
+	}
+}
+
+type fixedRateLimiter struct {
+	allowed bool
+	keys    []string
+}
+
+func (f *fixedRateLimiter) Allow(key string) bool {
+	f.keys = append(f.keys, key)
+	return f.allowed
+}
+
+func TestLoginRateLimited(t *testing.T) {
+	loginFormRenderer, err := NewLoginFormRenderer("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	limiter := &fixedRateLimiter{allowed: false}
+	auth := &testAuth{Success: true, User: &user.DefaultInfo{Name: "user"}}
+	login := NewLoginWithOptions("myprovider", &csrf.FakeCSRF{Token: "test"}, auth, loginFormRenderer, LoginOptions{
+		RateLimiter:         limiter,
+		RateLimitByUsername: true,
+	})
+	server := httptest.NewServer(login)
+
+	resp, err := postForm(server.URL+"/login", url.Values{
+		"csrf":     []string{"test"},
+		"username": []string{"user"},
+		"password": []string{"pass"},
+		"then":     []string{"/done"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "Too many login attempts") {
+		t.Errorf("expected rendered error page, got %q", string(body))
+	}
+	if auth.Called {
+		t.Errorf("authenticator must not be called when throttled")
+	}
+	if len(limiter.keys) != 1 || limiter.keys[0] != "127.0.0.1/user" {
+		t.Errorf("unexpected rate limit keys: %v", limiter.keys)
+	}
+}
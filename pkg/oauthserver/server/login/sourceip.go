@@ -0,0 +1,77 @@
+package login
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	xForwardedForHeader = "X-Forwarded-For"
+	xRealIPHeader       = "X-Real-IP"
+)
+
+// SourceIPExtractor determines the client IP used for rate limiting and audit
+// entries. Forwarding headers are only honored when the immediate peer is a
+// trusted proxy; otherwise any client could spoof the rate-limit/audit key.
+type SourceIPExtractor struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewSourceIPExtractor returns an extractor that trusts forwarding headers
+// from peers within the given CIDRs. With no CIDRs, it always uses the
+// request's RemoteAddr.
+func NewSourceIPExtractor(trustedProxyCIDRs []string) (*SourceIPExtractor, error) {
+	var trustedProxies []*net.IPNet
+	for _, cidr := range trustedProxyCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted proxy CIDR %q: %v", cidr, err)
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+	return &SourceIPExtractor{trustedProxies: trustedProxies}, nil
+}
+
+// SourceIP returns the client IP for the given request. If the immediate peer
+// is a trusted proxy, the X-Real-IP header or the rightmost X-Forwarded-For
+// entry (the one appended by the trusted proxy, which the client cannot
+// forge) is used. In all other cases the peer address itself is returned.
+func (e *SourceIPExtractor) SourceIP(req *http.Request) string {
+	peer := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+
+	if e == nil || !e.isTrustedProxy(peer) {
+		return peer
+	}
+
+	if realIP := strings.TrimSpace(req.Header.Get(xRealIPHeader)); len(realIP) > 0 {
+		if net.ParseIP(realIP) != nil {
+			return realIP
+		}
+	}
+	if forwardedFor := req.Header.Get(xForwardedForHeader); len(forwardedFor) > 0 {
+		entries := strings.Split(forwardedFor, ",")
+		lastEntry := strings.TrimSpace(entries[len(entries)-1])
+		if net.ParseIP(lastEntry) != nil {
+			return lastEntry
+		}
+	}
+	return peer
+}
+
+func (e *SourceIPExtractor) isTrustedProxy(peer string) bool {
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil {
+		return false
+	}
+	for _, trusted := range e.trustedProxies {
+		if trusted.Contains(peerIP) {
+			return true
+		}
+	}
+	return false
+}
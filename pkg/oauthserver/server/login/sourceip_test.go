@@ -0,0 +1,88 @@
+package login
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSourceIP(t *testing.T) {
+	trusting, err := NewSourceIPExtractor([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	untrusting, err := NewSourceIPExtractor(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]struct {
+		Extractor  *SourceIPExtractor
+		RemoteAddr string
+		Header     http.Header
+		ExpectIP   string
+	}{
+		"no proxy trust uses RemoteAddr": {
+			Extractor:  untrusting,
+			RemoteAddr: "192.168.1.1:1234",
+			Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
+			ExpectIP:   "192.168.1.1",
+		},
+		"nil extractor uses RemoteAddr": {
+			Extractor:  nil,
+			RemoteAddr: "192.168.1.1:1234",
+			Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
+			ExpectIP:   "192.168.1.1",
+		},
+		"spoofed header from untrusted peer is ignored": {
+			Extractor:  trusting,
+			RemoteAddr: "192.168.1.1:1234",
+			Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
+			ExpectIP:   "192.168.1.1",
+		},
+		"forwarded header from trusted proxy is honored": {
+			Extractor:  trusting,
+			RemoteAddr: "10.0.0.5:1234",
+			Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
+			ExpectIP:   "1.2.3.4",
+		},
+		"rightmost forwarded entry wins": {
+			Extractor:  trusting,
+			RemoteAddr: "10.0.0.5:1234",
+			Header:     http.Header{"X-Forwarded-For": []string{"6.6.6.6, 1.2.3.4"}},
+			ExpectIP:   "1.2.3.4",
+		},
+		"X-Real-IP from trusted proxy is honored": {
+			Extractor:  trusting,
+			RemoteAddr: "10.0.0.5:1234",
+			Header:     http.Header{"X-Real-Ip": []string{"1.2.3.4"}},
+			ExpectIP:   "1.2.3.4",
+		},
+		"garbage forwarded value falls back to peer": {
+			Extractor:  trusting,
+			RemoteAddr: "10.0.0.5:1234",
+			Header:     http.Header{"X-Forwarded-For": []string{"not-an-ip"}},
+			ExpectIP:   "10.0.0.5",
+		},
+	}
+
+	for k, testCase := range testCases {
+		req, err := http.NewRequest("POST", "https://example.com/login", nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		req.RemoteAddr = testCase.RemoteAddr
+		for key, values := range testCase.Header {
+			req.Header[key] = values
+		}
+		if ip := testCase.Extractor.SourceIP(req); ip != testCase.ExpectIP {
+			t.Errorf("%s: expected %q, got %q", k, testCase.ExpectIP, ip)
+		}
+	}
+}
+
+func TestNewSourceIPExtractorInvalidCIDR(t *testing.T) {
+	if _, err := NewSourceIPExtractor([]string{"bogus"}); err == nil {
+		t.Errorf("expected error for invalid CIDR")
+	}
+}
@@ -130,6 +130,35 @@ type ManifestDeleter interface {
 	DeleteManifest(registryClient *http.Client, registryURL *url.URL, repo, manifest string) error
 }
 
+// KeepPolicy is a combined retention policy: images younger than YoungerThan
+// are kept, and so are the most recent TagRevisions revisions of every image
+// stream tag. It is an alternative to setting KeepYoungerThan and
+// KeepTagRevisions separately, for callers that carry the minimum age as a
+// duration string (eg from a config file).
+type KeepPolicy struct {
+	// YoungerThan is the minimum image age, in any format accepted by
+	// time.ParseDuration.
+	YoungerThan string
+	// TagRevisions is the number of revisions per image stream tag to preserve.
+	TagRevisions int
+}
+
+// Resolve validates the policy and returns the minimum image age as a
+// time.Duration.
+func (kp *KeepPolicy) Resolve() (time.Duration, error) {
+	youngerThan, err := time.ParseDuration(kp.YoungerThan)
+	if err != nil {
+		return 0, fmt.Errorf("invalid keep policy: cannot parse younger-than duration %q: %v", kp.YoungerThan, err)
+	}
+	if youngerThan < 0 {
+		return 0, fmt.Errorf("invalid keep policy: younger-than duration %q must not be negative", kp.YoungerThan)
+	}
+	if kp.TagRevisions < 0 {
+		return 0, fmt.Errorf("invalid keep policy: tag revisions must not be negative")
+	}
+	return youngerThan, nil
+}
+
 // PrunerOptions contains the fields used to initialize a new Pruner.
 type PrunerOptions struct {
 	// KeepYoungerThan indicates the minimum age an Image must be to be a
@@ -138,6 +167,10 @@ type PrunerOptions struct {
 	// KeepTagRevisions is the minimum number of tag revisions to preserve;
 	// revisions older than this value are candidates for pruning.
 	KeepTagRevisions *int
+	// KeepPolicy combines the minimum image age and the number of preserved
+	// tag revisions into a single policy. When set, it takes precedence over
+	// KeepYoungerThan and KeepTagRevisions.
+	KeepPolicy *KeepPolicy
 	// PruneOverSizeLimit indicates that images exceeding defined limits (openshift.io/Image)
 	// will be considered as candidates for pruning.
 	PruneOverSizeLimit *bool
@@ -285,6 +318,14 @@ func NewPruner(options PrunerOptions) (Pruner, kerrors.Aggregate) {
 	if options.KeepTagRevisions != nil {
 		algorithm.keepTagRevisions = *options.KeepTagRevisions
 	}
+	if options.KeepPolicy != nil {
+		youngerThan, err := options.KeepPolicy.Resolve()
+		if err != nil {
+			return nil, kerrors.NewAggregate([]error{err})
+		}
+		algorithm.keepYoungerThan = metav1.Now().Add(-youngerThan)
+		algorithm.keepTagRevisions = options.KeepPolicy.TagRevisions
+	}
 	if options.PruneOverSizeLimit != nil {
 		algorithm.pruneOverSizeLimit = *options.PruneOverSizeLimit
 	}
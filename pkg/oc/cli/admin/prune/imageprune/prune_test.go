@@ -1711,6 +1711,207 @@ func TestImageWithStrongAndWeakRefsIsNotPruned(t *testing.T) {
 	}
 }
 
+func TestKeepPolicyResolve(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      KeepPolicy
+		expected    time.Duration
+		expectError bool
+	}{
+		{
+			name:     "valid",
+			policy:   KeepPolicy{YoungerThan: "90m", TagRevisions: 3},
+			expected: 90 * time.Minute,
+		},
+		{
+			name:     "zero duration",
+			policy:   KeepPolicy{YoungerThan: "0s"},
+			expected: 0,
+		},
+		{
+			name:        "unparseable duration",
+			policy:      KeepPolicy{YoungerThan: "tomorrow", TagRevisions: 3},
+			expectError: true,
+		},
+		{
+			name:        "negative duration",
+			policy:      KeepPolicy{YoungerThan: "-1h", TagRevisions: 3},
+			expectError: true,
+		},
+		{
+			name:        "negative tag revisions",
+			policy:      KeepPolicy{YoungerThan: "1h", TagRevisions: -1},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		youngerThan, err := test.policy.Resolve()
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: unexpected non-error", test.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if youngerThan != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, youngerThan)
+		}
+	}
+}
+
+func TestKeepPolicyCombinesAgeAndTagRevisions(t *testing.T) {
+	flag.Lookup("v").Value.Set(fmt.Sprint(*logLevel))
+	registryHost := "registry1.io"
+
+	images := testutil.ImageList(
+		// young and unreferenced - kept because of YoungerThan
+		testutil.AgedImage("sha256:0000000000000000000000000000000000000000000000000000000000000001", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000001", 30),
+		// old, current and previous tag revisions - kept because of TagRevisions
+		testutil.AgedImage("sha256:0000000000000000000000000000000000000000000000000000000000000002", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000002", 240),
+		testutil.AgedImage("sha256:0000000000000000000000000000000000000000000000000000000000000003", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000003", 240),
+		// old third tag revision - pruned
+		testutil.AgedImage("sha256:0000000000000000000000000000000000000000000000000000000000000004", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000004", 240),
+	)
+	streams := testutil.StreamList(
+		testutil.Stream("registry1", "foo", "bar", []imagev1.NamedTagEventList{
+			testutil.Tag("latest",
+				testutil.TagEvent("sha256:0000000000000000000000000000000000000000000000000000000000000002", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000002"),
+				testutil.TagEvent("sha256:0000000000000000000000000000000000000000000000000000000000000003", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000003"),
+				testutil.TagEvent("sha256:0000000000000000000000000000000000000000000000000000000000000004", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000004"),
+			),
+		}),
+	)
+	pods := testutil.PodList()
+	rcs := testutil.RCList()
+	bcs := testutil.BCList()
+	builds := testutil.BuildList()
+	dss := testutil.DSList()
+	deployments := testutil.DeploymentList()
+	dcs := testutil.DCList()
+	rss := testutil.RSList()
+
+	options := PrunerOptions{
+		KeepPolicy:    &KeepPolicy{YoungerThan: "1h", TagRevisions: 2},
+		Images:        &images,
+		ImageWatcher:  watch.NewFake(),
+		Streams:       &streams,
+		StreamWatcher: watch.NewFake(),
+		Pods:          &pods,
+		RCs:           &rcs,
+		BCs:           &bcs,
+		Builds:        &builds,
+		DSs:           &dss,
+		Deployments:   &deployments,
+		DCs:           &dcs,
+		RSs:           &rss,
+		RegistryClientFactory: FakeRegistryClientFactory,
+		RegistryURL:           &url.URL{Scheme: "https", Host: registryHost},
+	}
+	p, err := NewPruner(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	imageDeleter, imageDeleterFactory := newFakeImageDeleter(nil)
+	streamDeleter := &fakeImageStreamDeleter{invocations: sets.NewString()}
+	layerLinkDeleter := &fakeLayerLinkDeleter{invocations: sets.NewString()}
+	blobDeleter := &fakeBlobDeleter{invocations: sets.NewString()}
+	manifestDeleter := &fakeManifestDeleter{invocations: sets.NewString()}
+
+	_, failures := p.Prune(imageDeleterFactory, streamDeleter, layerLinkDeleter, blobDeleter, manifestDeleter)
+	if len(failures) != 0 {
+		t.Errorf("got unexpected failures: %#+v", failures)
+	}
+
+	expectedImageDeletions := sets.NewString("sha256:0000000000000000000000000000000000000000000000000000000000000004")
+	if a, e := imageDeleter.invocations, expectedImageDeletions; !reflect.DeepEqual(a, e) {
+		t.Fatalf("unexpected image deletions: %s", diff.ObjectDiff(a, e))
+	}
+
+	// An unparseable policy is reported by NewPruner
+	options.KeepPolicy = &KeepPolicy{YoungerThan: "fortnight", TagRevisions: 2}
+	if _, err := NewPruner(options); err == nil {
+		t.Fatalf("expected error creating pruner with invalid keep policy")
+	}
+}
+
+func TestSharedConfigBlobNotPruned(t *testing.T) {
+	flag.Lookup("v").Value.Set(fmt.Sprint(*logLevel))
+	registryHost := "registry1.io"
+	registryURL := "https://" + registryHost
+
+	config1 := testutil.Config1
+	keptImage := testutil.ImageWithLayers("sha256:0000000000000000000000000000000000000000000000000000000000000001", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000001", &config1, testutil.Layer1, testutil.Layer2)
+	prunedImage := testutil.ImageWithLayers("sha256:0000000000000000000000000000000000000000000000000000000000000002", registryHost+"/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000002", &config1, testutil.Layer1, testutil.Layer3)
+
+	images := testutil.ImageList(keptImage, prunedImage)
+	streams := testutil.StreamList(
+		testutil.Stream(registryHost, "foo", "bar", []imagev1.NamedTagEventList{
+			testutil.Tag("latest",
+				testutil.TagEvent(keptImage.Name, registryHost+"/foo/bar@"+keptImage.Name),
+			),
+		}),
+	)
+	pods := testutil.PodList()
+	rcs := testutil.RCList()
+	bcs := testutil.BCList()
+	builds := testutil.BuildList()
+	dss := testutil.DSList()
+	deployments := testutil.DeploymentList()
+	dcs := testutil.DCList()
+	rss := testutil.RSList()
+
+	options := PrunerOptions{
+		Images:        &images,
+		ImageWatcher:  watch.NewFake(),
+		Streams:       &streams,
+		StreamWatcher: watch.NewFake(),
+		Pods:          &pods,
+		RCs:           &rcs,
+		BCs:           &bcs,
+		Builds:        &builds,
+		DSs:           &dss,
+		Deployments:   &deployments,
+		DCs:           &dcs,
+		RSs:           &rss,
+		RegistryClientFactory: FakeRegistryClientFactory,
+		RegistryURL:           &url.URL{Scheme: "https", Host: registryHost},
+	}
+	keepYoungerThan := time.Hour
+	keepTagRevisions := 3
+	options.KeepYoungerThan = &keepYoungerThan
+	options.KeepTagRevisions = &keepTagRevisions
+	p, err := NewPruner(options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	imageDeleter, imageDeleterFactory := newFakeImageDeleter(nil)
+	streamDeleter := &fakeImageStreamDeleter{invocations: sets.NewString()}
+	layerLinkDeleter := &fakeLayerLinkDeleter{invocations: sets.NewString()}
+	blobDeleter := &fakeBlobDeleter{invocations: sets.NewString()}
+	manifestDeleter := &fakeManifestDeleter{invocations: sets.NewString()}
+
+	_, failures := p.Prune(imageDeleterFactory, streamDeleter, layerLinkDeleter, blobDeleter, manifestDeleter)
+	if len(failures) != 0 {
+		t.Errorf("got unexpected failures: %#+v", failures)
+	}
+
+	if a, e := imageDeleter.invocations, sets.NewString(prunedImage.Name); !a.Equal(e) {
+		t.Fatalf("unexpected image deletions: %s", diff.ObjectDiff(a, e))
+	}
+
+	// The manifest and the unshared layer of the pruned image are deleted, but
+	// the config blob and the layer shared with the kept image survive.
+	expectedBlobDeletions := sets.NewString(
+		registryURL+"|"+prunedImage.Name,
+		registryURL+"|"+testutil.Layer3,
+	)
+	if a, e := blobDeleter.invocations, expectedBlobDeletions; !a.Equal(e) {
+		t.Fatalf("unexpected blob deletions: %s", diff.ObjectDiff(a, e))
+	}
+}
+
 func TestImageIsPrunable(t *testing.T) {
 	g := genericgraph.New()
 	imageNode := imagegraph.EnsureImageNode(g, &imagev1.Image{ObjectMeta: metav1.ObjectMeta{Name: "myImage"}})
@@ -61,31 +61,74 @@ func GetHostIPNetworks(skipInterfaces []string) ([]*net.IPNet, []net.IP, error)
 	return hostIPNets, hostIPs, kerrors.NewAggregate(errList)
 }
 
+// IPFamilyPreference selects which address family GetNodeIPWithPreference
+// favors when a node resolves to both IPv4 and IPv6 addresses.
+type IPFamilyPreference string
+
+const (
+	PreferIPv4 IPFamilyPreference = "ipv4"
+	PreferIPv6 IPFamilyPreference = "ipv6"
+)
+
+// NoUsableAddressError indicates the node name resolved, but every address
+// was loopback or link-local. It is distinct from a lookup failure.
+type NoUsableAddressError struct {
+	Node string
+}
+
+func (e *NoUsableAddressError) Error() string {
+	return fmt.Sprintf("no usable IP address for node %s", e.Node)
+}
+
+// for testing
+var lookupIP = net.LookupIP
+
 func GetNodeIP(nodeName string) (string, error) {
-	ip := net.ParseIP(nodeName)
-	if ip == nil {
-		addrs, err := net.LookupIP(nodeName)
+	return GetNodeIPWithPreference(nodeName, PreferIPv4)
+}
+
+// GetNodeIPWithPreference resolves nodeName to a usable, non-loopback,
+// non-link-local IP address, favoring the given address family but falling
+// back to the other for single-stack hosts.
+func GetNodeIPWithPreference(nodeName string, preference IPFamilyPreference) (string, error) {
+	var addrs []net.IP
+	if ip := net.ParseIP(nodeName); ip != nil {
+		addrs = []net.IP{ip}
+	} else {
+		var err error
+		addrs, err = lookupIP(nodeName)
 		if err != nil {
 			return "", fmt.Errorf("Failed to lookup IP address for node %s: %v", nodeName, err)
 		}
-		for _, addr := range addrs {
-			// Skip loopback and non IPv4 addrs
-			if addr.IsLoopback() || addr.To4() == nil {
-				glog.V(5).Infof("Skipping loopback/non-IPv4 addr: %q for node %s", addr.String(), nodeName)
-				continue
-			}
-			ip = addr
-			break
-		}
-	} else if ip.IsLoopback() || ip.To4() == nil {
-		glog.V(5).Infof("Skipping loopback/non-IPv4 addr: %q for node %s", ip.String(), nodeName)
-		ip = nil
 	}
 
-	if ip == nil || len(ip.String()) == 0 {
-		return "", fmt.Errorf("Failed to obtain IP address from node name: %s", nodeName)
+	if ip := firstUsableIP(addrs, preference, nodeName); ip != nil {
+		return ip.String(), nil
+	}
+	otherFamily := PreferIPv6
+	if preference == PreferIPv6 {
+		otherFamily = PreferIPv4
+	}
+	if ip := firstUsableIP(addrs, otherFamily, nodeName); ip != nil {
+		return ip.String(), nil
+	}
+	return "", &NoUsableAddressError{Node: nodeName}
+}
+
+// firstUsableIP returns the first address of the given family that is neither
+// loopback nor link-local, or nil.
+func firstUsableIP(addrs []net.IP, family IPFamilyPreference, nodeName string) net.IP {
+	for _, ip := range addrs {
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			glog.V(5).Infof("Skipping loopback/link-local addr: %q for node %s", ip.String(), nodeName)
+			continue
+		}
+		if (ip.To4() != nil) != (family == PreferIPv4) {
+			continue
+		}
+		return ip
 	}
-	return ip.String(), nil
+	return nil
 }
 
 // ParseCIDRMask parses a CIDR string and ensures that it has no bits set beyond the
@@ -1,6 +1,7 @@
 package netutils
 
 import (
+	"fmt"
 	"net"
 	"strings"
 	"testing"
@@ -110,3 +111,92 @@ func TestIsPrivateAddress(t *testing.T) {
 		}
 	}
 }
+
+func TestGetNodeIPWithPreference(t *testing.T) {
+	origLookupIP := lookupIP
+	defer func() { lookupIP = origLookupIP }()
+
+	tests := []struct {
+		name       string
+		addrs      []string
+		lookupErr  error
+		preference IPFamilyPreference
+		expectedIP string
+		expectErr  bool
+		expectNoIP bool
+	}{
+		{
+			name:       "prefer IPv4 on dual-stack",
+			addrs:      []string{"fd00::5", "192.168.1.5"},
+			preference: PreferIPv4,
+			expectedIP: "192.168.1.5",
+		},
+		{
+			name:       "prefer IPv6 on dual-stack",
+			addrs:      []string{"192.168.1.5", "fd00::5"},
+			preference: PreferIPv6,
+			expectedIP: "fd00::5",
+		},
+		{
+			name:       "fall back to IPv6 on v6-only host",
+			addrs:      []string{"fd00::5"},
+			preference: PreferIPv4,
+			expectedIP: "fd00::5",
+		},
+		{
+			name:       "fall back to IPv4 on v4-only host",
+			addrs:      []string{"192.168.1.5"},
+			preference: PreferIPv6,
+			expectedIP: "192.168.1.5",
+		},
+		{
+			name:       "skip loopback and link-local",
+			addrs:      []string{"127.0.0.1", "::1", "fe80::1", "192.168.1.5"},
+			preference: PreferIPv6,
+			expectedIP: "192.168.1.5",
+		},
+		{
+			name:       "no usable address",
+			addrs:      []string{"127.0.0.1", "::1", "fe80::1"},
+			preference: PreferIPv4,
+			expectErr:  true,
+			expectNoIP: true,
+		},
+		{
+			name:       "lookup failure",
+			lookupErr:  fmt.Errorf("no such host"),
+			preference: PreferIPv4,
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		lookupIP = func(host string) ([]net.IP, error) {
+			if test.lookupErr != nil {
+				return nil, test.lookupErr
+			}
+			var addrs []net.IP
+			for _, addr := range test.addrs {
+				addrs = append(addrs, net.ParseIP(addr))
+			}
+			return addrs, nil
+		}
+
+		ip, err := GetNodeIPWithPreference("node.example.com", test.preference)
+		if test.expectErr {
+			if err == nil {
+				t.Fatalf("%s: expected error, got IP %q", test.name, ip)
+			}
+			if _, isNoUsable := err.(*NoUsableAddressError); isNoUsable != test.expectNoIP {
+				t.Fatalf("%s: wrong error type: %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if ip != test.expectedIP {
+			t.Fatalf("%s: expected IP %q, got %q", test.name, test.expectedIP, ip)
+		}
+	}
+}